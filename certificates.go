@@ -0,0 +1,21 @@
+package main
+
+import "clearblade-iot-enterprise-migration/migrator"
+
+// runCertificates adds the CA certificates of the registry to the target,
+// without touching any device.
+func runCertificates(arguments []string) int {
+	args := &cliArgs{}
+	fs := newFlagSet("certificates")
+	initMigrationFlags(fs, args)
+	_ = fs.Parse(arguments)
+	defer args.closeLog()
+
+	m, err := connectMigrator(args)
+	if err != nil {
+		return printError(err)
+	}
+
+	results, err := m.MigrateCACertificates()
+	return finishMigration(m, &migrator.Result{CACertificates: results}, err)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"clearblade-iot-enterprise-migration/migrator"
+)
+
+const programName = "clearblade-iot-enterprise-migration"
+
+// command is a subcommand of the CLI. Every command parses its own flags and
+// returns the exit code of the tool.
+type command struct {
+	name        string
+	description string
+	run         func(arguments []string) int
+}
+
+func commands() []*command {
+	return []*command{
+		{"migrate", "Migrate devices from the device source to the target. This is the default when only flags are given", runMigrate},
+		{"plan", "List the devices a migration would create or update, without changing the target", runPlan},
+		{"credentials", "Check the device keys of the device source before a migration, without connecting to the target", runCredentials},
+		{"verify", "Compare the migrated devices, keys and roles in the target with the device source", runVerify},
+		{"export", "Write the devices of a ClearBlade IoT Core registry to a local archive", runExport},
+		{"import", "Migrate the devices of an archive created by the export command", runImport},
+		{"retry", "Migrate again the devices listed in a failed_devices CSV file", runRetry},
+		{"roles", "Create the role of each migrated device and assign it to the device", runRoles},
+		{"certificates", "Add the CA certificates of the registry to the target, without migrating devices", runCertificates},
+		{"keys", "Replace the public keys of each migrated device with the keys from the device source", runKeys},
+		{"version", "Print the version of the tool", runVersion},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", programName)
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(w, "\nRun %s <command> -h to view the flags of a command.\n", programName)
+}
+
+// newFlagSet creates the flag set of a command, with a usage message that
+// describes the command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n", programName, name)
+		if cmd := findCommand(name); cmd != nil {
+			fmt.Fprintf(fs.Output(), "%s.\n\n", cmd.description)
+		}
+		fmt.Fprintln(fs.Output(), "Flags:")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func runVersion(arguments []string) int {
+	fs := newFlagSet("version")
+	_ = fs.Parse(arguments)
+
+	fmt.Printf("%s\n", migrator.Version)
+	return exitSuccess
+}
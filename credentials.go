@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"clearblade-iot-enterprise-migration/migrator"
+)
+
+// runCredentials checks the credentials of the selected devices of the source,
+// and exits with a partial failure when a migration would fail devices because
+// of them.
+func runCredentials(arguments []string) int {
+	args := &cliArgs{}
+	fs := newFlagSet("credentials")
+	initSourceFlags(fs, args)
+	initCbIotCoreFlags(fs, args)
+	initCredentialsFlags(fs, args)
+	initLogFlags(fs, args)
+	_ = fs.Parse(arguments)
+	defer args.closeLog()
+
+	switch args.SourceType {
+	case migrator.SourceTypeIotCore:
+		validateCBFlags(args)
+	case migrator.SourceTypeArchive, migrator.SourceTypeInventory:
+		validateSourcePath(args)
+	}
+
+	if err := args.openLog(); err != nil {
+		fatalConfig("Invalid log flags: ", err)
+	}
+
+	m, err := migrator.New(args.Options)
+	if err != nil {
+		return printError(err)
+	}
+	defer m.Close()
+
+	report, err := m.CheckCredentials()
+	if err != nil {
+		return printError(err)
+	}
+
+	printCredentialReport(report)
+	if len(report.FailedDevices()) > 0 || len(report.MissingIds) > 0 {
+		return exitPartialFailure
+	}
+	return exitSuccess
+}
+
+func initCredentialsFlags(fs *flag.FlagSet, args *cliArgs) {
+	fs.StringVar(&args.DevicesFile, "devicesCsv", "", "Devices list file path (CSV, JSON array or NDJSON). Use - to read the list from stdin")
+	fs.IntVar(&args.PageSize, "pageSize", 100, "Page Size")
+	fs.IntVar(&args.FetchWorkers, "fetchWorkers", 5, "Number of concurrent device list requests when fetching devices from a CSV file. Default is 5")
+	fs.BoolVar(&args.silentMode, "silentMode", false, "Run this tool in silent (non-interactive) mode. Default is false")
+	initExpiredCredentialsFlag(fs, args)
+}
+
+func printCredentialReport(report *migrator.CredentialReport) {
+	fmt.Println(string(colorCyan), "\n\n================= Credential Report =================\n", string(colorReset))
+
+	for _, check := range report.Credentials {
+		if check.Status == migrator.CredentialValid {
+			continue
+		}
+
+		color := colorYellow
+		if check.Action == migrator.CredentialActionFail {
+			color = colorRed
+		}
+		details := []string{check.Status, check.Action}
+		if !check.ExpiresAt.IsZero() {
+			details = append(details, "expires "+check.ExpiresAt.UTC().Format(time.RFC3339))
+		}
+		line := fmt.Sprintf("  %s credential %d (%s)", check.DeviceId, check.Index, strings.Join(details, ", "))
+		if check.Err != nil {
+			line += ": " + check.Err.Error()
+		}
+		fmt.Printf("%s%s\n%s", string(color), line, string(colorReset))
+	}
+
+	fmt.Println(string(colorGreen), "\n\u2713", len(report.Credentials), "credentials of", report.Devices, "devices:",
+		report.Count(migrator.CredentialValid), "valid,",
+		report.Count(migrator.CredentialExpired), "expired,",
+		report.Count(migrator.CredentialUnsupported), "unsupported,",
+		report.Count(migrator.CredentialMalformed), "malformed", string(colorReset))
+
+	if failed := report.FailedDevices(); len(failed) > 0 {
+		fmt.Printf("%sA migration would fail the following devices - %s\n%s", string(colorRed), strings.Join(failed, ", "), string(colorReset))
+	}
+	if len(report.KeylessDevices) > 0 {
+		fmt.Printf("%sNote: the following devices have no credentials and can only connect with an active key - %s\n%s", string(colorYellow), strings.Join(report.KeylessDevices, ", "), string(colorReset))
+	}
+	if len(report.MissingIds) > 0 {
+		fmt.Printf("%sWarning: the following device IDs were not found - %s\n%s", string(colorYellow), strings.Join(report.MissingIds, ", "), string(colorReset))
+	}
+}
@@ -0,0 +1,1663 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"clearblade-iot-enterprise-migration/migrator"
+	cb "github.com/clearblade/Go-SDK"
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// e2eEnv is a migration from a fake IoT Core registry to a fake IoT
+// Enterprise system. Runs happen in a temporary working directory so failed
+// device reports can be inspected.
+type e2eEnv struct {
+	iotCore    *fakeIotCore
+	enterprise *fakeEnterprise
+	dir        string
+	args       *cliArgs
+}
+
+func newE2EEnv(t *testing.T) *e2eEnv {
+	env := &e2eEnv{
+		iotCore:    newFakeIotCore(t),
+		enterprise: newFakeEnterprise(t),
+		dir:        t.TempDir(),
+	}
+
+	t.Setenv("CLEARBLADE_CONFIGURATION", "")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(env.dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+
+	env.args = &cliArgs{
+		Options: migrator.Options{
+			ServiceAccount:   env.iotCore.WriteServiceAccount(t, env.dir),
+			RegistryName:     env.iotCore.Registry,
+			RegistryRegion:   env.iotCore.Region,
+			EnterpriseUrl:    env.enterprise.URL,
+			EnterpriseMsgUrl: strings.TrimPrefix(env.enterprise.URL, "http://"),
+			SystemKey:        fakeSystemKey,
+			SystemSecret:     fakeSystemSecret,
+			DevEmail:         fakeDevEmail,
+			DevPassword:      fakeDevPassword,
+			SourceType:       migrator.SourceTypeIotCore,
+			TargetType:       migrator.TargetTypeEnterprise,
+			PageSize:         2,
+			FetchWorkers:     2,
+			UpdatePublicKeys: true,
+			CreateDeviceRole: true,
+		},
+		silentMode: true,
+		logLevel:   "info",
+		logFormat:  "text",
+	}
+	return env
+}
+
+// flags returns the command line flags matching env.args.
+func (env *e2eEnv) flags() []string {
+	return []string{
+		"-cbServiceAccount", env.args.ServiceAccount,
+		"-cbRegistryName", env.args.RegistryName,
+		"-cbRegistryRegion", env.args.RegistryRegion,
+		"-cbEnterpriseUrl", env.args.EnterpriseUrl,
+		"-cbEnterpriseMsgUrl", env.args.EnterpriseMsgUrl,
+		"-cbSystemKey", env.args.SystemKey,
+		"-cbSystemSecret", env.args.SystemSecret,
+		"-cbDevEmail", env.args.DevEmail,
+		"-cbDevPwd", env.args.DevPassword,
+		"-pageSize", fmt.Sprint(env.args.PageSize),
+		"-fetchWorkers", fmt.Sprint(env.args.FetchWorkers),
+		"-updatePublicKeys=" + fmt.Sprint(env.args.UpdatePublicKeys),
+		"-createDeviceRole=" + fmt.Sprint(env.args.CreateDeviceRole),
+		"-silentMode",
+	}
+}
+
+// failedDevices returns the context of every error in the failed device
+// reports, keyed by device ID.
+func (env *e2eEnv) failedDevices(t *testing.T) map[string][]string {
+	files, err := filepath.Glob(filepath.Join(env.dir, "failed_devices_*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := make(map[string][]string)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatalf("invalid failed devices report %s: %v", file, err)
+		}
+
+		for _, record := range records[1:] {
+			deviceId := record[len(record)-1]
+			failed[deviceId] = append(failed[deviceId], record[0])
+		}
+	}
+	return failed
+}
+
+func e2eDevices(t testing.TB) []*cbiotcore.Device {
+	return []*cbiotcore.Device{
+		{
+			Id: "device-1",
+			Credentials: []*cbiotcore.DeviceCredential{
+				testCredential(t, "rsa-key-1", "RSA_PEM", "1970-01-01T00:00:00Z"),
+			},
+		},
+		{
+			Id: "device-2",
+			Credentials: []*cbiotcore.DeviceCredential{
+				testCredential(t, "es-cert-2", "ES256_X509_PEM", "2030-01-01T00:00:00Z"),
+			},
+		},
+		{
+			Id: "device-3",
+			Credentials: []*cbiotcore.DeviceCredential{
+				testCredential(t, "es-key-3a", "ES256_PEM", ""),
+				testCredential(t, "rsa-cert-3b", "RSA_X509_PEM", ""),
+			},
+		},
+		{Id: "device-4", Blocked: true},
+		{Id: "device-5", Metadata: map[string]string{"site": "plant-5"}},
+	}
+}
+
+var (
+	testKeysMu sync.Mutex
+	testKeys   = make(map[string]string)
+)
+
+// testCredential returns a device credential of the given IoT Core format
+// holding a valid key. Keys are generated once per name, so the devices built
+// by separate calls of e2eDevices hold the same keys.
+func testCredential(t testing.TB, name, format, expirationTime string) *cbiotcore.DeviceCredential {
+	return &cbiotcore.DeviceCredential{
+		PublicKey:      &cbiotcore.PublicKeyCredential{Format: format, Key: testKeyPEM(t, name, format, time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC))},
+		ExpirationTime: expirationTime,
+	}
+}
+
+// testKeyPEM returns the PEM encoded key named name in the given IoT Core
+// format. Certificates are self-signed and valid until notAfter.
+func testKeyPEM(t testing.TB, name, format string, notAfter time.Time) string {
+	t.Helper()
+
+	testKeysMu.Lock()
+	defer testKeysMu.Unlock()
+
+	if key, ok := testKeys[name]; ok {
+		return key
+	}
+
+	var signer crypto.Signer
+	var err error
+	switch format {
+	case "RSA_PEM", "RSA_X509_PEM":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256_PEM", "ES256_X509_PEM":
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		t.Fatalf("no test key for format %s", format)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	switch format {
+	case "RSA_PEM", "ES256_PEM":
+		der, err := x509.MarshalPKIXPublicKey(signer.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	default:
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:     notAfter,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "CERTIFICATE", Bytes: der}
+	}
+
+	key := string(pem.EncodeToMemory(block))
+	testKeys[name] = key
+	return key
+}
+
+func assertDeviceMigrated(t *testing.T, env *e2eEnv, source *cbiotcore.Device) {
+	t.Helper()
+
+	device := env.enterprise.Device(source.Id)
+	if device == nil {
+		t.Errorf("device %s was not migrated", source.Id)
+		return
+	}
+	if device["enabled"] != !source.Blocked {
+		t.Errorf("device %s: enabled = %v, want %v", source.Id, device["enabled"], !source.Blocked)
+	}
+
+	if env.args.UpdatePublicKeys {
+		keys := env.enterprise.Keys(source.Id)
+		if len(keys) != len(source.Credentials) {
+			t.Errorf("device %s has %d keys, want %d", source.Id, len(keys), len(source.Credentials))
+		} else {
+			for i, cred := range source.Credentials {
+				if keys[i]["public_key"] != cred.PublicKey.Key {
+					t.Errorf("device %s key %d = %v, want %s", source.Id, i, keys[i]["public_key"], cred.PublicKey.Key)
+				}
+			}
+		}
+	}
+
+	// Roles do not depend on the keys, so devices without any get one too
+	if !env.args.CreateDeviceRole {
+		return
+	}
+
+	role := env.enterprise.Role(source.Id)
+	if role == nil {
+		t.Errorf("role %s was not created", source.Id)
+		return
+	}
+	if len(role.Topics) != 5 {
+		t.Errorf("role %s has %d topics, want 5", source.Id, len(role.Topics))
+	}
+	if level := role.Topics["/devices/"+source.Id+"/commands/#"]; level != cb.PERM_READ {
+		t.Errorf("role %s: commands topic permission = %d, want %d", source.Id, level, cb.PERM_READ)
+	}
+	if level := role.Topics["/devices/"+source.Id+"/events/#"]; level != cb.PERM_CREATE {
+		t.Errorf("role %s: events topic permission = %d, want %d", source.Id, level, cb.PERM_CREATE)
+	}
+	if roles := env.enterprise.DeviceRoles(source.Id); len(roles) != 1 || roles[0] != source.Id {
+		t.Errorf("device %s roles = %v, want [%s]", source.Id, roles, source.Id)
+	}
+}
+
+func TestE2EMigrateRegistry(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	if count := env.enterprise.DeviceCount(); count != len(devices) {
+		t.Fatalf("migrated %d devices, want %d", count, len(devices))
+	}
+	for _, device := range devices {
+		assertDeviceMigrated(t, env, device)
+	}
+
+	keys := env.enterprise.Keys("device-2")
+	if keys[0]["key_format"] != float64(cb.ES256_X509) || keys[0]["expiration_time"] != "2030-01-01T00:00:00Z" {
+		t.Errorf("device-2 key = %v, want an ES256 X.509 key expiring 2030-01-01T00:00:00Z", keys[0])
+	}
+	if _, ok := env.enterprise.Keys("device-1")[0]["expiration_time"]; ok {
+		t.Errorf("device-1 key has an expiration time, want none for the zero time")
+	}
+
+	// Five devices at two devices per page
+	if pages := env.iotCore.Requests(http.MethodGet, "/api/v/4/webhook/execute/*/cloudiot_devices"); pages != 3 {
+		t.Errorf("listed the registry in %d pages, want 3", pages)
+	}
+	if failed := env.failedDevices(t); len(failed) > 0 {
+		t.Errorf("failed devices = %v, want none", failed)
+	}
+}
+
+func TestE2EMigrateRerun(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	if count := env.enterprise.DeviceCount(); count != len(devices) {
+		t.Fatalf("migrated %d devices, want %d", count, len(devices))
+	}
+	// Existing keys are replaced rather than duplicated, and existing roles
+	// and role assignments are reused
+	for _, device := range devices {
+		assertDeviceMigrated(t, env, device)
+	}
+	if failed := env.failedDevices(t); len(failed) > 0 {
+		t.Errorf("failed devices = %v, want none", failed)
+	}
+}
+
+func TestE2EMigrateRerunStructuredConflict(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	// A conflict reported in the error body rather than the status is still
+	// recognized, and the existing device is updated
+	env.enterprise.Inject(fault{
+		Method: http.MethodPost,
+		Path:   "/admin/devices/*/device-1",
+		Status: http.StatusInternalServerError,
+		Body:   `{"error": {"code": 409, "message": "Device already exists"}}`,
+	})
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	if updates := env.enterprise.Requests(http.MethodPut, "/admin/devices/*/device-1"); updates != 1 {
+		t.Errorf("updated device-1 %d times, want 1", updates)
+	}
+	if failed := env.failedDevices(t); len(failed) > 0 {
+		t.Errorf("failed devices = %v, want none", failed)
+	}
+}
+
+func TestE2EMigrateWithoutKeys(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+	env.args.UpdatePublicKeys = false
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	for _, device := range devices {
+		assertDeviceMigrated(t, env, device)
+		if keys := env.enterprise.Keys(device.Id); len(keys) > 0 {
+			t.Errorf("device %s has %d keys, want none", device.Id, len(keys))
+		}
+	}
+}
+
+func TestE2EMigrateKeylessDevices(t *testing.T) {
+	env := newE2EEnv(t)
+	env.iotCore.AddDevices(e2eDevices(t)...)
+
+	result, err := env.newMigrator(t).Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyless := result.KeylessDevices()
+	slices.Sort(keyless)
+	if !slices.Equal(keyless, []string{"device-4", "device-5"}) {
+		t.Errorf("keyless devices = %v, want device-4 and device-5", keyless)
+	}
+	if result.Migrated != result.Devices {
+		t.Errorf("migrated %d of %d devices, want all", result.Migrated, result.Devices)
+	}
+	for _, id := range keyless {
+		if role := env.enterprise.Role(id); role == nil {
+			t.Errorf("role %s was not created", id)
+		}
+	}
+
+	report, err := env.newMigrator(t).CheckCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(report.KeylessDevices)
+	if !slices.Equal(report.KeylessDevices, keyless) {
+		t.Errorf("credential report keyless devices = %v, want %v", report.KeylessDevices, keyless)
+	}
+
+	// The roles command sets up the roles of keyless devices too
+	env = newE2EEnv(t)
+	env.iotCore.AddDevices(e2eDevices(t)...)
+	env.args.CreateDeviceRole = false
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	if code := runRoles(env.flags()); code != exitSuccess {
+		t.Fatalf("roles exit code = %d, want %d", code, exitSuccess)
+	}
+	for _, id := range keyless {
+		if roles := env.enterprise.DeviceRoles(id); len(roles) != 1 || roles[0] != id {
+			t.Errorf("device %s roles = %v, want [%s]", id, roles, id)
+		}
+	}
+}
+
+func TestE2EMigrateRoleConfig(t *testing.T) {
+	env := newE2EEnv(t)
+	env.iotCore.AddDevices(e2eDevices(t)...)
+
+	env.args.RoleConfigFile = filepath.Join(env.dir, "roles.json")
+	content := `{"topics": [
+		{"topic": "/{registry}/{device_id}/telemetry", "permissions": ["publish"]},
+		{"topic": "/sites/{metadata.site}/broadcast", "permissions": ["subscribe", "publish"]}
+	]}`
+	if err := os.WriteFile(env.args.RoleConfigFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only device-5 has the site metadata of the second topic
+	if code := runMigration(env.args); code != exitPartialFailure {
+		t.Fatalf("exit code = %d, want %d", code, exitPartialFailure)
+	}
+
+	role := env.enterprise.Role("device-5")
+	if role == nil {
+		t.Fatal("role device-5 was not created")
+	}
+	want := map[string]int{
+		"/" + env.iotCore.Registry + "/device-5/telemetry": cb.PERM_CREATE,
+		"/sites/plant-5/broadcast":                         cb.PERM_READ | cb.PERM_CREATE,
+	}
+	if !maps.Equal(role.Topics, want) {
+		t.Errorf("role device-5 topics = %v, want %v", role.Topics, want)
+	}
+
+	failed := env.failedDevices(t)
+	for _, id := range []string{"device-1", "device-2", "device-3", "device-4"} {
+		if steps := failed[id]; len(steps) != 1 || steps[0] != "Error when adding topic to role" {
+			t.Errorf("%s failed steps = %v, want the topic step", id, steps)
+		}
+	}
+	if _, ok := failed["device-5"]; ok {
+		t.Errorf("device-5 failed: %v", failed["device-5"])
+	}
+
+	// An invalid configuration is a configuration error
+	if err := os.WriteFile(env.args.RoleConfigFile, []byte(`{"topics": [{"topic": "/{tenant}", "permissions": ["read"]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runMigration(env.args); code != exitConfigError {
+		t.Errorf("invalid configuration exit code = %d, want %d", code, exitConfigError)
+	}
+}
+
+func TestE2EMigrateRolePlatformPermissions(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	env.args.RoleConfigFile = filepath.Join(env.dir, "roles.json")
+	content := `{
+		"topics": [{"topic": "/devices/{device_id}/events/#", "permissions": ["publish"]}],
+		"collections": [{"collection": "a8c9d0e1f2", "permissions": ["read", "create"]}],
+		"services": [{"service": "lookupDevice", "permissions": ["execute"]}],
+		"devices": {"permissions": ["read"]}
+	}`
+	if err := os.WriteFile(env.args.RoleConfigFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The devices permissions reach every device row, even for per-device
+	// roles, so they must be allowed
+	if code := runMigration(env.args); code != exitConfigError {
+		t.Fatalf("exit code without -allowSharedDeviceAccess = %d, want %d", code, exitConfigError)
+	}
+	if count := env.enterprise.DeviceCount(); count != 0 {
+		t.Fatalf("%d devices migrated with a refused role configuration, want none", count)
+	}
+
+	env.args.RoleStrategy = migrator.RoleStrategyShared
+	env.args.AllowSharedDeviceAccess = true
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	role := env.enterprise.Role(migrator.DefaultSharedRoleName)
+	if role == nil {
+		t.Fatal("shared role was not created")
+	}
+	if want := map[string]int{"a8c9d0e1f2": cb.PERM_READ | cb.PERM_CREATE}; !maps.Equal(role.Collections, want) {
+		t.Errorf("role collections = %v, want %v", role.Collections, want)
+	}
+	if want := map[string]int{"lookupDevice": cb.PERM_READ}; !maps.Equal(role.Services, want) {
+		t.Errorf("role services = %v, want %v", role.Services, want)
+	}
+	if role.Devices != cb.PERM_READ {
+		t.Errorf("role devices permission = %d, want %d", role.Devices, cb.PERM_READ)
+	}
+	if want := map[string]int{"/devices/+/events/#": cb.PERM_CREATE}; !maps.Equal(role.Topics, want) {
+		t.Errorf("role topics = %v, want %v", role.Topics, want)
+	}
+}
+
+func TestE2EMigrateSharedRoles(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	// Per-device roles first, then consolidated into a shared role
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	if roles := env.enterprise.RoleNames(); len(roles) != len(devices) {
+		t.Fatalf("roles = %v, want one per device", roles)
+	}
+
+	// Shared roles widen the access of every device, which must be allowed
+	// before any per-device role is deleted
+	for _, flags := range [][]string{{"-roleStrategy", "shared"}, {"-roleStrategy", "shared", "-consolidateRoles"}} {
+		if code := runRoles(append(env.flags(), flags...)); code != exitConfigError {
+			t.Fatalf("roles %v exit code = %d, want %d", flags, code, exitConfigError)
+		}
+	}
+	if roles := env.enterprise.RoleNames(); len(roles) != len(devices) {
+		t.Fatalf("roles = %v after a refused consolidation, want one per device", roles)
+	}
+
+	if code := runRoles(append(env.flags(), "-roleStrategy", "shared", "-consolidateRoles", "-allowSharedDeviceAccess")); code != exitSuccess {
+		t.Fatalf("roles exit code = %d, want %d", code, exitSuccess)
+	}
+	if roles := env.enterprise.RoleNames(); !slices.Equal(roles, []string{migrator.DefaultSharedRoleName}) {
+		t.Fatalf("roles = %v, want only the shared role", roles)
+	}
+	for _, device := range devices {
+		if roles := env.enterprise.DeviceRoles(device.Id); !slices.Equal(roles, []string{migrator.DefaultSharedRoleName}) {
+			t.Errorf("device %s roles = %v, want the shared role", device.Id, roles)
+		}
+	}
+
+	// Device tokens of shared roles become wildcards
+	role := env.enterprise.Role(migrator.DefaultSharedRoleName)
+	if level, ok := role.Topics["/devices/+/events/#"]; !ok || level != cb.PERM_CREATE || len(role.Topics) != 5 {
+		t.Errorf("shared role topics = %v, want the IoT Core layout with wildcards", role.Topics)
+	}
+
+	env.args.RoleStrategy = migrator.RoleStrategyShared
+	report, err := env.newMigrator(t).Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Errorf("mismatches = %+v, want none", report.Mismatches)
+	}
+
+	// One role per device type, with the type of the device list first
+	env = newE2EEnv(t)
+	env.iotCore.AddDevices(devices...)
+	env.args.RoleStrategy = migrator.RoleStrategyType
+	env.args.AllowSharedDeviceAccess = true
+	env.args.DeviceType = "sensor"
+	env.args.DevicesFile = filepath.Join(env.dir, "devices.json")
+	list := `[{"id": "device-1", "type": "gateway"}, "device-2", "device-3", "device-4", "device-5"]`
+	if err := os.WriteFile(env.args.DevicesFile, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("type strategy exit code = %d, want %d", code, exitSuccess)
+	}
+	if roles := env.enterprise.RoleNames(); !slices.Equal(roles, []string{"devices-gateway", "devices-sensor"}) {
+		t.Errorf("roles = %v, want one per device type", roles)
+	}
+	if roles := env.enterprise.DeviceRoles("device-1"); !slices.Equal(roles, []string{"devices-gateway"}) {
+		t.Errorf("device-1 roles = %v, want devices-gateway", roles)
+	}
+
+	// Consolidating per-device roles needs a shared strategy
+	if code := runRoles(append(env.flags(), "-consolidateRoles")); code != exitConfigError {
+		t.Errorf("consolidation without a shared strategy exit code = %d, want %d", code, exitConfigError)
+	}
+}
+
+func TestE2EConsolidateRoleCollisions(t *testing.T) {
+	// A device named after the shared role keeps it
+	env := newE2EEnv(t)
+	devices := append(e2eDevices(t), &cbiotcore.Device{Id: migrator.DefaultSharedRoleName})
+	env.iotCore.AddDevices(devices...)
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	if code := runRoles(append(env.flags(), "-roleStrategy", "shared", "-consolidateRoles", "-allowSharedDeviceAccess")); code != exitSuccess {
+		t.Fatalf("roles exit code = %d, want %d", code, exitSuccess)
+	}
+	if roles := env.enterprise.RoleNames(); !slices.Equal(roles, []string{migrator.DefaultSharedRoleName}) {
+		t.Fatalf("roles = %v, want only the shared role", roles)
+	}
+	for _, device := range devices {
+		if roles := env.enterprise.DeviceRoles(device.Id); !slices.Equal(roles, []string{migrator.DefaultSharedRoleName}) {
+			t.Errorf("device %s roles = %v, want the shared role", device.Id, roles)
+		}
+	}
+
+	// A device named after the role of another device type keeps it, once
+	// the role is shared
+	env = newE2EEnv(t)
+	devices = append(e2eDevices(t), &cbiotcore.Device{Id: "devices-sensor"})
+	env.iotCore.AddDevices(devices...)
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	env.args.RoleStrategy = migrator.RoleStrategyType
+	env.args.AllowSharedDeviceAccess = true
+	env.args.DeviceType = "sensor"
+	env.args.DevicesFile = filepath.Join(env.dir, "devices.json")
+	if err := os.WriteFile(env.args.DevicesFile, []byte(`["device-1"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("type strategy exit code = %d, want %d", code, exitSuccess)
+	}
+	sensorRole := env.enterprise.Role("devices-sensor")
+
+	env.args.ConsolidateRoles = true
+	list := `[{"id": "devices-sensor", "type": "gateway"}, "device-1", "device-2", "device-3", "device-4", "device-5"]`
+	if err := os.WriteFile(env.args.DevicesFile, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("consolidation exit code = %d, want %d", code, exitSuccess)
+	}
+	if roles := env.enterprise.RoleNames(); !slices.Equal(roles, []string{"devices-gateway", "devices-sensor"}) {
+		t.Errorf("roles = %v, want one per device type", roles)
+	}
+	if role := env.enterprise.Role("devices-sensor"); role == nil || role.ID != sensorRole.ID {
+		t.Errorf("devices-sensor role = %+v, want the role %s", role, sensorRole.ID)
+	}
+	if roles := env.enterprise.DeviceRoles("devices-sensor"); !slices.Equal(slices.Sorted(slices.Values(roles)), []string{"devices-gateway", "devices-sensor"}) {
+		t.Errorf("devices-sensor roles = %v, want devices-gateway next to the role it already held", roles)
+	}
+	for _, device := range devices[:len(devices)-1] {
+		if roles := env.enterprise.DeviceRoles(device.Id); !slices.Equal(roles, []string{"devices-sensor"}) {
+			t.Errorf("device %s roles = %v, want devices-sensor", device.Id, roles)
+		}
+	}
+}
+
+func TestE2EMigrateDeviceList(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	// Slow list calls make concurrent batches finish out of order
+	env.iotCore.Inject(fault{
+		Method:  http.MethodGet,
+		Path:    "/api/v/4/webhook/execute/*/cloudiot_devices",
+		Latency: 50 * time.Millisecond,
+		Times:   1,
+	})
+
+	env.args.DevicesFile = filepath.Join(env.dir, "devices.csv")
+	env.args.FetchWorkers = 3
+	deviceList := "id,type\ndevice-5,sensor\ndevice-1,\nmissing-device,\ndevice-3,\n"
+	if err := os.WriteFile(env.args.DevicesFile, []byte(deviceList), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// missing-device is not in the registry
+	if code := runMigration(env.args); code != exitPartialFailure {
+		t.Fatalf("exit code = %d, want %d", code, exitPartialFailure)
+	}
+
+	if count := env.enterprise.DeviceCount(); count != 3 {
+		t.Fatalf("migrated %d devices, want 3", count)
+	}
+	for _, device := range []*cbiotcore.Device{devices[0], devices[2], devices[4]} {
+		assertDeviceMigrated(t, env, device)
+	}
+	if deviceType := env.enterprise.Device("device-5")["type"]; deviceType != "sensor" {
+		t.Errorf("device-5 type = %v, want the sensor override", deviceType)
+	}
+	// Four IDs at two devices per batch
+	if batches := env.iotCore.Requests(http.MethodGet, "/api/v/4/webhook/execute/*/cloudiot_devices"); batches != 2 {
+		t.Errorf("fetched the device list in %d batches, want 2", batches)
+	}
+}
+
+func TestE2EMigrateDeviceListFetchFailure(t *testing.T) {
+	env := newE2EEnv(t)
+	env.iotCore.AddDevices(e2eDevices(t)...)
+	env.iotCore.Inject(fault{Method: http.MethodGet, Path: "/api/v/4/webhook/execute/*/cloudiot_devices", Status: http.StatusInternalServerError, Times: 1})
+
+	// Five IDs at two devices per batch, fetched one batch at a time
+	env.args.DevicesFile = filepath.Join(env.dir, "devices.json")
+	env.args.FetchWorkers = 1
+	deviceList := `["device-1", "device-2", "device-3", "device-4", "device-5"]`
+	if err := os.WriteFile(env.args.DevicesFile, []byte(deviceList), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := runMigration(env.args); code != exitSourceFailure {
+		t.Fatalf("exit code = %d, want %d", code, exitSourceFailure)
+	}
+
+	// The failed first batch cancels the others
+	if batches := env.iotCore.Requests(http.MethodGet, "/api/v/4/webhook/execute/*/cloudiot_devices"); batches != 1 {
+		t.Errorf("fetched %d batches after the first one failed, want only the failed one", batches)
+	}
+}
+
+func TestE2EMigrateReportsFailedDevices(t *testing.T) {
+	tests := []struct {
+		name     string
+		fault    fault
+		deviceId string
+	}{
+		{
+			name:     "device create server error",
+			fault:    fault{Method: http.MethodPost, Path: "/admin/devices/*/device-2", Status: http.StatusInternalServerError},
+			deviceId: "device-2",
+		},
+		{
+			name:     "device create rate limited",
+			fault:    fault{Method: http.MethodPost, Path: "/admin/devices/*/device-5", Status: http.StatusTooManyRequests},
+			deviceId: "device-5",
+		},
+		{
+			name:     "public key server error",
+			fault:    fault{Method: http.MethodPost, Path: "/admin/devices/public_keys/*/device-2", Status: http.StatusInternalServerError},
+			deviceId: "device-2",
+		},
+		{
+			name:     "public key rate limited",
+			fault:    fault{Method: http.MethodPost, Path: "/admin/devices/public_keys/*/device-3", Status: http.StatusTooManyRequests},
+			deviceId: "device-3",
+		},
+		{
+			name:     "device role rate limited",
+			fault:    fault{Method: http.MethodPut, Path: "/admin/devices/roles/*/device-1", Status: http.StatusTooManyRequests},
+			deviceId: "device-1",
+		},
+		{
+			name:     "device role server error",
+			fault:    fault{Method: http.MethodPut, Path: "/admin/devices/roles/*/device-3", Status: http.StatusInternalServerError, Times: 1},
+			deviceId: "device-3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newE2EEnv(t)
+			devices := e2eDevices(t)
+			env.iotCore.AddDevices(devices...)
+			env.enterprise.Inject(tt.fault)
+
+			if code := runMigration(env.args); code != exitPartialFailure {
+				t.Fatalf("exit code = %d, want %d", code, exitPartialFailure)
+			}
+
+			failed := env.failedDevices(t)
+			if _, ok := failed[tt.deviceId]; !ok || len(failed) != 1 {
+				t.Fatalf("failed devices = %v, want only %s", failed, tt.deviceId)
+			}
+			for _, device := range devices {
+				if device.Id != tt.deviceId {
+					assertDeviceMigrated(t, env, device)
+				}
+			}
+		})
+	}
+}
+
+// TestE2EMigrateMalformedRoleResponse checks that a role create answered
+// with an unexpected body fails the device it was sent for, and only that
+// device. Role creates do not name the device in their path, so the failed
+// device is whichever device created its role first.
+func TestE2EMigrateMalformedRoleResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "no role", body: "null"},
+		{name: "role ID not a string", body: `{"role_id": 42}`},
+		{name: "not an object", body: `["role-1"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newE2EEnv(t)
+			devices := e2eDevices(t)
+			env.iotCore.AddDevices(devices...)
+			env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/user/*/roles", Status: http.StatusOK, Body: tt.body, Times: 1})
+
+			if code := runMigration(env.args); code != exitPartialFailure {
+				t.Fatalf("exit code = %d, want %d", code, exitPartialFailure)
+			}
+
+			failed := env.failedDevices(t)
+			if len(failed) != 1 {
+				t.Fatalf("failed devices = %v, want exactly one", failed)
+			}
+			for _, device := range devices {
+				if _, ok := failed[device.Id]; !ok {
+					assertDeviceMigrated(t, env, device)
+				}
+			}
+		})
+	}
+}
+
+// TestE2EMigrateExistingRoles checks that a role create failing because the
+// role exists, answered with a bad request by IoT Enterprise and a conflict by
+// other versions, uses the existing role, while any other failure is reported.
+func TestE2EMigrateExistingRoles(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	// The fake answers duplicate roles with a bad request
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("rerun exit code = %d, want %d", code, exitSuccess)
+	}
+
+	env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/user/*/roles", Status: http.StatusConflict})
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("rerun with conflicts exit code = %d, want %d", code, exitSuccess)
+	}
+	for _, device := range devices {
+		assertDeviceMigrated(t, env, device)
+	}
+
+	// Auth failures and other bad requests are not mistaken for an existing
+	// role
+	for _, status := range []int{http.StatusForbidden, http.StatusBadRequest} {
+		env = newE2EEnv(t)
+		env.iotCore.AddDevices(devices...)
+		env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/user/*/roles", Status: status})
+		if code := runMigration(env.args); code != exitPartialFailure {
+			t.Fatalf("exit code with role creates failing with %d = %d, want %d", status, code, exitPartialFailure)
+		}
+		if lookups := env.enterprise.Requests(http.MethodGet, "/admin/user/*/roles"); lookups != 0 {
+			t.Errorf("roles were looked up %d times after role creates failed with %d, want 0", lookups, status)
+		}
+		for _, device := range devices {
+			if steps := env.failedDevices(t)[device.Id]; len(steps) != 1 || steps[0] != "Error when Creating role" {
+				t.Errorf("%s failed steps = %v, want the role create", device.Id, steps)
+			}
+		}
+	}
+}
+
+func TestE2EMigrateInvalidCredentials(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+
+	// device-1 keeps its valid key next to an unsupported one, device-2 only
+	// has a key that does not match its format
+	devices[0].Credentials = append(devices[0].Credentials, &cbiotcore.DeviceCredential{
+		PublicKey: &cbiotcore.PublicKeyCredential{Format: "UNSPECIFIED_PUBLIC_KEY_FORMAT", Key: "unspecified"},
+	})
+	devices[1].Credentials = []*cbiotcore.DeviceCredential{
+		{PublicKey: &cbiotcore.PublicKeyCredential{Format: "ES256_X509_PEM", Key: testKeyPEM(t, "rsa-key-1", "RSA_PEM", time.Time{})}},
+	}
+	env.iotCore.AddDevices(devices...)
+
+	if code := runMigration(env.args); code != exitPartialFailure {
+		t.Fatalf("exit code = %d, want %d", code, exitPartialFailure)
+	}
+
+	failed := env.failedDevices(t)
+	want := map[string][]string{
+		"device-1": {"Unsupported device credential"},
+		"device-2": {"Malformed device credential"},
+	}
+	if fmt.Sprint(failed) != fmt.Sprint(want) {
+		t.Fatalf("failed devices = %v, want %v", failed, want)
+	}
+
+	if keys := env.enterprise.Keys("device-1"); len(keys) != 1 {
+		t.Errorf("device-1 has %d keys, want its valid key only", len(keys))
+	}
+	if keys := env.enterprise.Keys("device-2"); len(keys) != 0 {
+		t.Errorf("device-2 has %d keys, want none", len(keys))
+	}
+	for _, device := range devices[2:] {
+		assertDeviceMigrated(t, env, device)
+	}
+}
+
+// expiredDevices returns the e2e devices with an expired certificate added to
+// device-1, whose valid key is kept, and a past expiration time on the only
+// credential of device-2.
+func expiredDevices(t *testing.T) []*cbiotcore.Device {
+	devices := e2eDevices(t)
+	devices[0].Credentials = append(devices[0].Credentials, &cbiotcore.DeviceCredential{
+		PublicKey: &cbiotcore.PublicKeyCredential{Format: "ES256_X509_PEM", Key: testKeyPEM(t, "es-cert-expired", "ES256_X509_PEM", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))},
+	})
+	devices[1].Credentials[0].ExpirationTime = "2021-01-01T00:00:00Z"
+	return devices
+}
+
+func TestE2EMigrateExpiredCredentials(t *testing.T) {
+	tests := []struct {
+		policy string
+		code   int
+		keys   [2]int
+		failed map[string][]string
+	}{
+		{policy: migrator.ExpiredCredentialsMigrate, code: exitSuccess, keys: [2]int{2, 1}, failed: map[string][]string{}},
+		{policy: migrator.ExpiredCredentialsSkip, code: exitSuccess, keys: [2]int{1, 0}, failed: map[string][]string{}},
+		{
+			policy: migrator.ExpiredCredentialsFail,
+			code:   exitPartialFailure,
+			keys:   [2]int{1, 0},
+			failed: map[string][]string{
+				"device-1": {"Expired device credential"},
+				"device-2": {"Expired device credential"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			env := newE2EEnv(t)
+			devices := expiredDevices(t)
+			env.iotCore.AddDevices(devices...)
+
+			// An earlier run uploaded the expired keys, which the policy
+			// must not leave behind
+			env.args.ExpiredCredentials = migrator.ExpiredCredentialsMigrate
+			if code := runMigration(env.args); code != exitSuccess {
+				t.Fatalf("first run exit code = %d, want %d", code, exitSuccess)
+			}
+
+			env.args.ExpiredCredentials = tt.policy
+			if code := runMigration(env.args); code != tt.code {
+				t.Fatalf("exit code = %d, want %d", code, tt.code)
+			}
+
+			if failed := env.failedDevices(t); fmt.Sprint(failed) != fmt.Sprint(tt.failed) {
+				t.Errorf("failed devices = %v, want %v", failed, tt.failed)
+			}
+			for i, want := range tt.keys {
+				if keys := env.enterprise.Keys(devices[i].Id); len(keys) != want {
+					t.Errorf("%s has %d keys, want %d", devices[i].Id, len(keys), want)
+				}
+			}
+			for _, device := range devices[2:] {
+				assertDeviceMigrated(t, env, device)
+			}
+
+			// Verify expects the keys the policy uploads
+			report, err := env.newMigrator(t).Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mismatch := range report.Mismatches {
+				if mismatch.Field == "public_keys" {
+					t.Errorf("unexpected key mismatch: %+v", mismatch)
+				}
+			}
+		})
+	}
+}
+
+func TestE2ECredentialReport(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := expiredDevices(t)
+	devices[2].Credentials = append(devices[2].Credentials, &cbiotcore.DeviceCredential{
+		PublicKey: &cbiotcore.PublicKeyCredential{Format: "RSA_PEM", Key: "not a key"},
+	})
+	env.iotCore.AddDevices(devices...)
+	env.args.ExpiredCredentials = migrator.ExpiredCredentialsSkip
+
+	report, err := env.newMigrator(t).CheckCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Devices != len(devices) || len(report.Credentials) != 6 {
+		t.Fatalf("checked %d credentials of %d devices, want 6 of %d", len(report.Credentials), report.Devices, len(devices))
+	}
+	counts := map[string]int{
+		migrator.CredentialValid:       report.Count(migrator.CredentialValid),
+		migrator.CredentialExpired:     report.Count(migrator.CredentialExpired),
+		migrator.CredentialMalformed:   report.Count(migrator.CredentialMalformed),
+		migrator.CredentialUnsupported: report.Count(migrator.CredentialUnsupported),
+	}
+	want := map[string]int{
+		migrator.CredentialValid:       3,
+		migrator.CredentialExpired:     2,
+		migrator.CredentialMalformed:   1,
+		migrator.CredentialUnsupported: 0,
+	}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("credential counts = %v, want %v", counts, want)
+	}
+	for _, check := range report.Credentials {
+		if check.Status == migrator.CredentialExpired && (check.Action != migrator.CredentialActionSkip || check.ExpiresAt.Year() != 2021) {
+			t.Errorf("expired credential %+v, want skipped with its 2021 expiry", check)
+		}
+	}
+	if failed := report.FailedDevices(); fmt.Sprint(failed) != "[device-3]" {
+		t.Errorf("failed devices = %v, want [device-3]", failed)
+	}
+
+	// The command reads the source only and fails when devices would fail
+	code := runCredentials([]string{
+		"-cbServiceAccount", env.args.ServiceAccount,
+		"-cbRegistryName", env.args.RegistryName,
+		"-cbRegistryRegion", env.args.RegistryRegion,
+		"-expiredCredentials", migrator.ExpiredCredentialsFail,
+		"-silentMode",
+	})
+	if code != exitPartialFailure {
+		t.Errorf("exit code = %d, want %d", code, exitPartialFailure)
+	}
+	if requests := env.enterprise.Requests(http.MethodPost, "/admin/auth"); requests != 0 {
+		t.Errorf("the credential report authenticated with the target %d times", requests)
+	}
+}
+
+func TestE2EMigrateCACertificates(t *testing.T) {
+	validCert := testKeyPEM(t, "ca-valid", "ES256_X509_PEM", time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC))
+	expiredCert := testKeyPEM(t, "ca-expired", "RSA_X509_PEM", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		policy string
+		code   int
+		want   []string
+	}{
+		{policy: migrator.ExpiredCredentialsMigrate, code: exitSuccess, want: []string{validCert, expiredCert}},
+		{policy: migrator.ExpiredCredentialsSkip, code: exitSuccess, want: []string{validCert}},
+		{policy: migrator.ExpiredCredentialsFail, code: exitPartialFailure, want: []string{validCert}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			env := newE2EEnv(t)
+			env.iotCore.AddDevices(e2eDevices(t)...)
+			env.iotCore.AddCACertificates(validCert, expiredCert)
+			env.args.MigrateCACertificates = true
+			env.args.ExpiredCredentials = tt.policy
+
+			if code := runMigration(env.args); code != tt.code {
+				t.Fatalf("exit code = %d, want %d", code, tt.code)
+			}
+			if certs := env.enterprise.CACertificates(); fmt.Sprint(certs) != fmt.Sprint(tt.want) {
+				t.Fatalf("target has %d CA certificates, want %d", len(certs), len(tt.want))
+			}
+
+			// Certificates already in the target are not added again
+			if code := runCertificates(append(env.flags(), "-expiredCredentials", tt.policy)); code != tt.code {
+				t.Fatalf("rerun exit code = %d, want %d", code, tt.code)
+			}
+			if certs := env.enterprise.CACertificates(); len(certs) != len(tt.want) {
+				t.Errorf("target has %d CA certificates after a rerun, want %d", len(certs), len(tt.want))
+			}
+		})
+	}
+
+	// Certificates are only migrated on request
+	env := newE2EEnv(t)
+	env.iotCore.AddDevices(e2eDevices(t)...)
+	env.iotCore.AddCACertificates(validCert)
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	if certs := env.enterprise.CACertificates(); len(certs) != 0 {
+		t.Errorf("target has %d CA certificates, want none", len(certs))
+	}
+}
+
+func TestE2EMigrateDeviceAuth(t *testing.T) {
+	env := newE2EEnv(t)
+	env.iotCore.AddDevices(e2eDevices(t)...)
+
+	rules := filepath.Join(env.dir, "auth_rules.json")
+	content := `[
+		{"idPattern": "device-1", "allowKeyAuth": true},
+		{"metadata": {"site": "plant-5"}, "generateActiveKey": false}
+	]`
+	if err := os.WriteFile(rules, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := append(env.flags(), "-allowCertificateAuth=false", "-generateActiveKeys", "-deviceAuthRules", rules)
+	if code := runMigrate(flags); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	// Only device-4 has neither credentials nor a rule turning keys off
+	want := map[string]bool{"device-1": true, "device-2": false, "device-3": false, "device-4": true, "device-5": false}
+	for id, keyAuth := range want {
+		device := env.enterprise.Device(id)
+		if device["allow_key_auth"] != keyAuth || device["allow_certificate_auth"] != false {
+			t.Errorf("%s: allow_key_auth %v and allow_certificate_auth %v, want %v and false", id, device["allow_key_auth"], device["allow_certificate_auth"], keyAuth)
+		}
+		if _, ok := device["active_key"]; ok != (id == "device-4") {
+			t.Errorf("%s: active key %v", id, device["active_key"])
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(env.dir, "active_keys_*.csv"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("found active keys files %v, want one: %v", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeKey := env.enterprise.Device("device-4")["active_key"]
+	if len(records) != 2 || records[1][0] != "device-4" || records[1][1] != activeKey {
+		t.Fatalf("active keys file = %v, want the key of device-4", records)
+	}
+
+	// Keys are only generated on create, so a rerun keeps them
+	if code := runMigrate(flags); code != exitSuccess {
+		t.Fatalf("rerun exit code = %d, want %d", code, exitSuccess)
+	}
+	if key := env.enterprise.Device("device-4")["active_key"]; key != activeKey {
+		t.Errorf("rerun replaced the active key of device-4")
+	}
+	if keyAuth := env.enterprise.Device("device-4")["allow_key_auth"]; keyAuth != true {
+		t.Errorf("rerun set allow_key_auth of device-4 to %v, want it kept", keyAuth)
+	}
+
+	env.args.DisableCertificateAuth = true
+	env.args.GenerateActiveKeys = true
+	env.args.DeviceAuthRulesFile = rules
+	report, err := env.newMigrator(t).Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Errorf("mismatches = %+v, want none", report.Mismatches)
+	}
+
+	// Devices that already exist get no key, and so no key auth either
+	existing := newE2EEnv(t)
+	existing.iotCore.AddDevices(e2eDevices(t)...)
+	if code := runMigrate(existing.flags()); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	if code := runMigrate(append(existing.flags(), "-generateActiveKeys")); code != exitSuccess {
+		t.Fatalf("rerun with -generateActiveKeys exit code = %d, want %d", code, exitSuccess)
+	}
+	if device := existing.enterprise.Device("device-4"); device["allow_key_auth"] != false || device["active_key"] != nil {
+		t.Errorf("existing device-4: allow_key_auth %v and active key %v, want false and none", device["allow_key_auth"], device["active_key"])
+	}
+
+	// Invalid rules are a configuration error
+	if err := os.WriteFile(rules, []byte(`[{"idPattern": "device-1"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runMigrate(flags); code != exitConfigError {
+		t.Errorf("invalid rules exit code = %d, want %d", code, exitConfigError)
+	}
+}
+
+func TestE2EOneResultPerDevice(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	// Several failed steps for one device, failures in different steps for
+	// others, and slow devices so results arrive out of order
+	env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/devices/*/device-2", Status: http.StatusInternalServerError})
+	env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/devices/public_keys/*/device-3", Status: http.StatusTooManyRequests})
+	env.enterprise.Inject(fault{Method: http.MethodPut, Path: "/admin/devices/roles/*/device-1", Status: http.StatusInternalServerError})
+	env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/devices/*/device-1", Latency: 30 * time.Millisecond})
+	env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/devices/*/device-4", Latency: 20 * time.Millisecond})
+
+	result, err := env.newMigrator(t).Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Devices != len(devices) || len(result.DeviceResults) != len(devices) {
+		t.Fatalf("got %d devices and %d device results, want %d of each", result.Devices, len(result.DeviceResults), len(devices))
+	}
+	if result.Migrated != 2 {
+		t.Errorf("migrated %d devices, want 2", result.Migrated)
+	}
+
+	wantErrors := map[string][]string{
+		"device-1": {"Error when Creating role"},
+		"device-2": {"Error when Creating Device", "Error when Patching Device"},
+		"device-3": {"Error when creating device credential"},
+		"device-4": nil,
+		"device-5": nil,
+	}
+	failedSteps := 0
+	for _, deviceResult := range result.DeviceResults {
+		want, ok := wantErrors[deviceResult.DeviceId]
+		if !ok {
+			t.Errorf("unexpected or duplicate result for %s", deviceResult.DeviceId)
+			continue
+		}
+		delete(wantErrors, deviceResult.DeviceId)
+
+		contexts := make([]string, 0, len(deviceResult.Errors))
+		for _, errorLog := range deviceResult.Errors {
+			contexts = append(contexts, errorLog.Context)
+		}
+		if strings.Join(contexts, ", ") != strings.Join(want, ", ") {
+			t.Errorf("%s failed steps = %v, want %v", deviceResult.DeviceId, contexts, want)
+		}
+		failedSteps += len(contexts)
+	}
+	if len(wantErrors) > 0 {
+		t.Errorf("no result for %v", wantErrors)
+	}
+	if len(result.Failed) != failedSteps {
+		t.Errorf("got %d failed steps, want %d", len(result.Failed), failedSteps)
+	}
+}
+
+func TestE2ERetry(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+	// Error bodies are reported as is, with their quotes and commas
+	body := `{"error": {"code": 500, "message": "key \"rsa-1\" rejected, retry later"}}`
+	env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/devices/public_keys/*/device-2", Status: http.StatusInternalServerError, Body: body, Times: 1})
+
+	if code := runMigration(env.args); code != exitPartialFailure {
+		t.Fatalf("exit code = %d, want %d", code, exitPartialFailure)
+	}
+
+	files, err := filepath.Glob(filepath.Join(env.dir, "failed_devices_*.csv"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("failed devices reports = %v, want one (%v)", files, err)
+	}
+	deviceIds, err := readFailedDeviceIds(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deviceIds, ",") != "device-2" {
+		t.Fatalf("failed device IDs = %v, want [device-2]", deviceIds)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !strings.Contains(records[1][1], `key "rsa-1" rejected, retry later`) {
+		t.Errorf("failed devices report = %q, want the error message unchanged", records)
+	}
+
+	updates := env.enterprise.Requests(http.MethodPut, "/admin/devices/*/*")
+	if code := runRetry(append(env.flags(), "-failedDevices", files[0])); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	for _, device := range devices {
+		assertDeviceMigrated(t, env, device)
+	}
+	if retried := env.enterprise.Requests(http.MethodPut, "/admin/devices/*/*") - updates; retried != 1 {
+		t.Errorf("retry updated %d devices, want 1", retried)
+	}
+}
+
+func TestE2EKeysAndRoles(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+	env.args.UpdatePublicKeys = false
+	env.args.CreateDeviceRole = false
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	for _, device := range devices {
+		if keys := env.enterprise.Keys(device.Id); len(keys) > 0 {
+			t.Fatalf("device %s has %d keys, want none", device.Id, len(keys))
+		}
+	}
+
+	env.args.UpdatePublicKeys = true
+	env.args.CreateDeviceRole = true
+	if code := runKeys(env.flags()); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+	if code := runRoles(env.flags()); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	for _, device := range devices {
+		assertDeviceMigrated(t, env, device)
+	}
+	if failed := env.failedDevices(t); len(failed) > 0 {
+		t.Errorf("failed devices = %v, want none", failed)
+	}
+}
+
+func TestE2EExitCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(env *e2eEnv)
+		want  int
+	}{
+		{
+			name:  "unknown source type",
+			setup: func(env *e2eEnv) { env.args.SourceType = "bogus" },
+			want:  exitConfigError,
+		},
+		{
+			name:  "unreadable archive",
+			setup: func(env *e2eEnv) { env.args.SourceType, env.args.SourcePath = migrator.SourceTypeArchive, env.dir },
+			want:  exitSourceFailure,
+		},
+		{
+			name:  "unknown registry",
+			setup: func(env *e2eEnv) { env.args.RegistryName = "missing-registry" },
+			want:  exitSourceFailure,
+		},
+		{
+			name:  "wrong developer password",
+			setup: func(env *e2eEnv) { env.args.DevPassword = "wrong-password" },
+			want:  exitAuthFailure,
+		},
+		{
+			name: "device count failure",
+			setup: func(env *e2eEnv) {
+				env.iotCore.Inject(fault{Path: "/api/v/1/code/*/getNumDevicesGateways", Status: http.StatusInternalServerError})
+			},
+			want: exitSourceFailure,
+		},
+		{
+			name: "device list failure",
+			setup: func(env *e2eEnv) {
+				env.iotCore.Inject(fault{Method: http.MethodGet, Path: "/api/v/4/webhook/execute/*/cloudiot_devices", Status: http.StatusInternalServerError})
+			},
+			want: exitSourceFailure,
+		},
+		{
+			name:  "missing devices file",
+			setup: func(env *e2eEnv) { env.args.DevicesFile = filepath.Join(env.dir, "missing.csv") },
+			want:  exitConfigError,
+		},
+		{
+			name: "unknown column map attribute",
+			setup: func(env *e2eEnv) {
+				env.args.ColumnMapFile = filepath.Join(env.dir, "columns.csv")
+				if err := os.WriteFile(env.args.ColumnMapFile, []byte("Name,name\nSerial,serial\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: exitConfigError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newE2EEnv(t)
+			env.iotCore.AddDevices(e2eDevices(t)...)
+			tt.setup(env)
+
+			if code := runMigration(env.args); code != tt.want {
+				t.Errorf("exit code = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestE2ELogFile(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+	env.enterprise.Inject(fault{Method: http.MethodPost, Path: "/admin/devices/public_keys/*/device-2", Status: http.StatusInternalServerError})
+
+	logFile := filepath.Join(env.dir, "migration.log")
+	code := runMigrate(append(env.flags(), "-logFile", logFile, "-logLevel", "debug", "-logFormat", "json"))
+	if code != exitPartialFailure {
+		t.Fatalf("exit code = %d, want %d", code, exitPartialFailure)
+	}
+
+	// Secrets are redacted by key and by value
+	env.args.logFile, env.args.logLevel, env.args.logFormat = logFile, "info", "json"
+	if err := env.args.openLog(); err != nil {
+		t.Fatal(err)
+	}
+	env.args.Logger.Info("Leaking "+fakeDevPassword, "cbDevPwd", "anything", "error", fmt.Errorf("bad secret %s", fakeSystemSecret))
+	env.args.closeLog()
+
+	content, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), fakeDevPassword) || strings.Contains(string(content), fakeSystemSecret) || strings.Contains(string(content), "anything") {
+		t.Errorf("log file contains a secret:\n%s", content)
+	}
+
+	migrated := make(map[string]bool)
+	failedSteps := make(map[string]string)
+	creates := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log record %q: %v", line, err)
+		}
+		device, _ := record["device"].(string)
+		switch record["msg"] {
+		case "Device migrated":
+			migrated[device] = true
+		case "Device step failed":
+			failedSteps[device], _ = record["step"].(string)
+		case "Creating device":
+			creates++
+		}
+	}
+
+	if len(migrated) != len(devices)-1 || migrated["device-2"] {
+		t.Errorf("devices logged as migrated = %v, want all but device-2", migrated)
+	}
+	if failedSteps["device-2"] != "Error when creating device credential" {
+		t.Errorf("failed steps = %v, want the device-2 credential step", failedSteps)
+	}
+	if creates != len(devices) {
+		t.Errorf("logged %d device creates, want %d", creates, len(devices))
+	}
+
+	// Invalid log flags are rejected without a log file too
+	for _, args := range []*cliArgs{{logLevel: "verbose", logFormat: "text"}, {logLevel: "info", logFormat: "xml"}} {
+		if err := args.openLog(); err == nil {
+			args.closeLog()
+			t.Errorf("openLog with level %q and format %q succeeded, want an error", args.logLevel, args.logFormat)
+		}
+	}
+}
+
+func TestE2EMigrateSlowEnterprise(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+	env.enterprise.Inject(fault{Path: "/admin/devices/*/*", Latency: 20 * time.Millisecond})
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	for _, device := range devices {
+		assertDeviceMigrated(t, env, device)
+	}
+	if failed := env.failedDevices(t); len(failed) > 0 {
+		t.Errorf("failed devices = %v, want none", failed)
+	}
+}
+
+func TestE2EExportImport(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	gateway := &cbiotcore.Device{Id: "gateway-1", GatewayConfig: &cbiotcore.GatewayConfig{GatewayType: "GATEWAY"}}
+	env.iotCore.AddDevices(append(devices, gateway)...)
+	env.iotCore.Bind("gateway-1", "device-4")
+	env.iotCore.Bind("gateway-1", "device-5")
+	env.iotCore.AddConfig("device-1", &cbiotcore.DeviceConfig{Version: 1, BinaryData: "djE="})
+	env.iotCore.AddConfig("device-1", &cbiotcore.DeviceConfig{Version: 2, BinaryData: "djI="})
+	env.iotCore.AddState("device-1", &cbiotcore.DeviceState{BinaryData: "b24="})
+	caCert := testKeyPEM(t, "ca-export", "RSA_X509_PEM", time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC))
+	env.iotCore.AddCACertificates(caCert)
+
+	archiveDir := filepath.Join(env.dir, "archive")
+	runExport([]string{
+		"-cbServiceAccount", env.args.ServiceAccount,
+		"-cbRegistryName", env.args.RegistryName,
+		"-cbRegistryRegion", env.args.RegistryRegion,
+		"-silentMode",
+		"-out", archiveDir,
+	})
+
+	archive, err := migrator.OpenArchive(archiveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Manifest.DeviceCount != len(devices)+1 {
+		t.Errorf("exported %d devices, want %d", archive.Manifest.DeviceCount, len(devices)+1)
+	}
+
+	exported := make(map[string]*migrator.ArchiveDevice)
+	if err := archive.ReadDevices(func(device *migrator.ArchiveDevice) error {
+		exported[device.Device.Id] = device
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if configs := exported["device-1"].ConfigVersions; len(configs) != 2 || configs[0].Version != 2 {
+		t.Errorf("device-1 config versions = %v, want versions 2 and 1", configs)
+	}
+	if states := exported["device-1"].States; len(states) != 1 {
+		t.Errorf("device-1 has %d states, want 1", len(states))
+	}
+	if bound := exported["gateway-1"].BoundDevices; strings.Join(bound, ",") != "device-4,device-5" {
+		t.Errorf("gateway-1 bound devices = %v, want [device-4 device-5]", bound)
+	}
+
+	// Import into a new system that was never connected to the registry
+	target := newFakeEnterprise(t)
+	runImport([]string{
+		"-archive", archiveDir,
+		"-cbEnterpriseUrl", target.URL,
+		"-cbEnterpriseMsgUrl", strings.TrimPrefix(target.URL, "http://"),
+		"-cbSystemKey", fakeSystemKey,
+		"-cbSystemSecret", fakeSystemSecret,
+		"-cbDevEmail", fakeDevEmail,
+		"-cbDevPwd", fakeDevPassword,
+		"-createDeviceRole",
+		"-migrateCaCertificates",
+		"-silentMode",
+	})
+
+	env.enterprise = target
+	if certs := target.CACertificates(); len(certs) != 1 || certs[0] != caCert {
+		t.Errorf("imported CA certificates = %d, want the registry CA certificate", len(certs))
+	}
+	if count := target.DeviceCount(); count != len(devices)+1 {
+		t.Fatalf("imported %d devices, want %d", count, len(devices)+1)
+	}
+	for _, device := range devices {
+		assertDeviceMigrated(t, env, device)
+	}
+	if failed := env.failedDevices(t); len(failed) > 0 {
+		t.Errorf("failed devices = %v, want none", failed)
+	}
+}
+
+func (env *e2eEnv) newMigrator(t *testing.T) *migrator.Migrator {
+	opts := env.args.Options
+	opts.Output = io.Discard
+
+	m, err := migrator.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = m.Close()
+	})
+	return m
+}
+
+func TestE2EPlan(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+	env.enterprise.devices["device-2"] = map[string]interface{}{"name": "device-2"}
+
+	plan, err := env.newMigrator(t).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Devices) != len(devices) {
+		t.Fatalf("planned %d devices, want %d", len(plan.Devices), len(devices))
+	}
+	if creates := plan.Creates(); creates != len(devices)-1 {
+		t.Errorf("plan creates %d devices, want %d", creates, len(devices)-1)
+	}
+	for _, device := range plan.Devices {
+		want := migrator.PlanActionCreate
+		if device.Id == "device-2" {
+			want = migrator.PlanActionUpdate
+		}
+		if device.Action != want {
+			t.Errorf("device %s action = %s, want %s", device.Id, device.Action, want)
+		}
+		if device.Id == "device-3" && device.Credentials != 2 {
+			t.Errorf("device-3 has %d credentials, want 2", device.Credentials)
+		}
+		if len(device.InvalidCredentials) != 0 {
+			t.Errorf("device %s has invalid credentials: %v", device.Id, device.InvalidCredentials)
+		}
+	}
+
+	if count := env.enterprise.DeviceCount(); count != 1 {
+		t.Errorf("plan changed the target: %d devices, want 1", count)
+	}
+}
+
+func TestE2EVerify(t *testing.T) {
+	env := newE2EEnv(t)
+	devices := e2eDevices(t)
+	env.iotCore.AddDevices(devices...)
+
+	if code := runMigration(env.args); code != exitSuccess {
+		t.Fatalf("exit code = %d, want %d", code, exitSuccess)
+	}
+
+	report, err := env.newMigrator(t).Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified != len(devices) || len(report.Mismatches) > 0 {
+		t.Fatalf("verified %d devices with mismatches %v, want %d without mismatches", report.Verified, report.Mismatches, len(devices))
+	}
+
+	// Drift the target and verify again
+	env.enterprise.devices["device-4"]["enabled"] = true
+	env.enterprise.keys["device-3"] = env.enterprise.keys["device-3"][:1]
+	env.enterprise.deviceRoles["device-1"] = nil
+	delete(env.enterprise.devices, "device-5")
+
+	report, err = env.newMigrator(t).Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches := make(map[string]string)
+	for _, mismatch := range report.Mismatches {
+		mismatches[mismatch.DeviceId] = mismatch.Field
+	}
+	want := map[string]string{
+		"device-1": "roles",
+		"device-3": "public_keys",
+		"device-4": "enabled",
+		"device-5": "device",
+	}
+	if !maps.Equal(mismatches, want) {
+		t.Errorf("mismatches = %v, want %v", mismatches, want)
+	}
+	if report.Verified != 1 {
+		t.Errorf("verified %d devices, want 1", report.Verified)
+	}
+}
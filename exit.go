@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"clearblade-iot-enterprise-migration/migrator"
+)
+
+// Exit codes of the tool, documented in the README. Automation relies on
+// them, so existing values must not change.
+const (
+	exitSuccess = 0
+	// exitFailure is an unexpected error, such as a failed write to disk.
+	exitFailure = 1
+	// exitConfigError is a missing or invalid flag or input file.
+	exitConfigError = 2
+	// exitAuthFailure is a failure to authenticate with IoT Enterprise.
+	exitAuthFailure = 3
+	// exitSourceFailure is a failure to read devices from the source.
+	exitSourceFailure = 4
+	// exitPartialFailure means some devices failed, were missing from the
+	// source or, for verify, differ from the source.
+	exitPartialFailure = 5
+)
+
+// exitCode returns the exit code matching an error returned by the migrator.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, migrator.ErrInvalidOptions):
+		return exitConfigError
+	case migrator.IsAuth(err):
+		return exitAuthFailure
+	case errors.Is(err, migrator.ErrSource):
+		return exitSourceFailure
+	default:
+		return exitFailure
+	}
+}
+
+// printError prints an error returned by the migrator and returns the exit
+// code matching it.
+func printError(err error) int {
+	fmt.Println(string(colorRed), "\n\u2715", err.Error(), string(colorReset))
+	return exitCode(err)
+}
+
+// fatalConfig prints a configuration error and exits with exitConfigError.
+func fatalConfig(v ...interface{}) {
+	log.Println(v...)
+	os.Exit(exitConfigError)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"clearblade-iot-enterprise-migration/migrator"
+)
+
+func runExport(arguments []string) int {
+	args := &cliArgs{}
+	var outDir string
+	var exportOpts migrator.ExportOptions
+
+	fs := newFlagSet("export")
+	initCbIotCoreFlags(fs, args)
+	fs.IntVar(&args.PageSize, "pageSize", 100, "Page Size")
+	fs.BoolVar(&args.silentMode, "silentMode", false, "Run this tool in silent (non-interactive) mode. Default is false")
+	fs.StringVar(&outDir, "out", "", "Directory the archive will be written to (Required)")
+	fs.BoolVar(&exportOpts.SkipConfigs, "skipConfigs", false, "Do not export device config versions")
+	fs.BoolVar(&exportOpts.SkipStates, "skipStates", false, "Do not export device states")
+	fs.BoolVar(&exportOpts.SkipBindings, "skipBindings", false, "Do not export gateway bindings")
+	initLogFlags(fs, args)
+	_ = fs.Parse(arguments)
+	defer args.closeLog()
+
+	validateCBFlags(args)
+
+	if outDir == "" {
+		if args.silentMode {
+			fatalConfig("-out is a required parameter")
+		}
+		value, err := readInput("Enter the directory the archive will be written to: ")
+		if err != nil {
+			fatalConfig("Error reading output directory: ", err)
+		}
+		outDir = value
+	}
+
+	if err := args.openLog(); err != nil {
+		fatalConfig("Invalid log flags: ", err)
+	}
+
+	m, err := migrator.New(args.Options)
+	if err != nil {
+		return printError(err)
+	}
+
+	fmt.Println(string(colorGreen), "\n\u2713 All Flags validated!", string(colorReset))
+
+	errorLogs, err := m.Export(outDir, exportOpts)
+	if err != nil {
+		return printError(err)
+	}
+
+	if len(errorLogs) > 0 {
+		if err := generateFailedDevicesCSV(errorLogs); err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+	}
+
+	fmt.Println(string(colorGreen), "\n\n\u2713 Done!", string(colorReset))
+
+	if len(errorLogs) > 0 {
+		return exitPartialFailure
+	}
+	return exitSuccess
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+const (
+	fakeSystemKey    = "enterprise-system-key"
+	fakeSystemSecret = "enterprise-system-secret"
+	fakeDevEmail     = "developer@example.com"
+	fakeDevPassword  = "developer-password"
+	fakeDevToken     = "developer-token"
+)
+
+// fakeEnterpriseRole is a role and the permission level of each of its topics,
+// collections, services and of the devices table.
+type fakeEnterpriseRole struct {
+	ID          string
+	Name        string
+	Topics      map[string]int
+	Collections map[string]int
+	Services    map[string]int
+	Devices     int
+}
+
+// fakeEnterprise serves the ClearBlade IoT Enterprise developer endpoints used
+// by the migration tool for a single system: authentication, devices, device
+// public keys, roles, role permissions, role deletes, device roles and root CA
+// certificates.
+// Conflicts are answered with the same messages as the platform.
+type fakeEnterprise struct {
+	fakeServer
+
+	stateMu     sync.Mutex
+	devices     map[string]map[string]interface{}
+	keys        map[string][]map[string]interface{}
+	roles       map[string]*fakeEnterpriseRole
+	roleSeq     int
+	deviceRoles map[string][]string
+	caCerts     []string
+}
+
+func newFakeEnterprise(t *testing.T) *fakeEnterprise {
+	f := &fakeEnterprise{
+		devices:     make(map[string]map[string]interface{}),
+		keys:        make(map[string][]map[string]interface{}),
+		roles:       make(map[string]*fakeEnterpriseRole),
+		deviceRoles: make(map[string][]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/auth", f.authenticate)
+	mux.HandleFunc("GET /admin/devices/{systemKey}", f.requireDeveloper(f.getDevices))
+	mux.HandleFunc("POST /admin/devices/{systemKey}/{name}", f.requireDeveloper(f.createDevice))
+	mux.HandleFunc("PUT /admin/devices/{systemKey}/{name}", f.requireDeveloper(f.updateDevice))
+	mux.HandleFunc("GET /admin/devices/public_keys/{systemKey}/{name}", f.requireDeveloper(f.getPublicKeys))
+	mux.HandleFunc("POST /admin/devices/public_keys/{systemKey}/{name}", f.requireDeveloper(f.addPublicKey))
+	mux.HandleFunc("DELETE /admin/devices/public_keys/{systemKey}/{name}", f.requireDeveloper(f.deletePublicKeys))
+	mux.HandleFunc("POST /admin/user/{systemKey}/roles", f.requireDeveloper(f.createRole))
+	mux.HandleFunc("GET /admin/user/{systemKey}/roles", f.requireDeveloper(f.getRoles))
+	mux.HandleFunc("PUT /admin/user/{systemKey}/roles", f.requireDeveloper(f.updateRole))
+	mux.HandleFunc("DELETE /admin/user/{systemKey}/roles", f.requireDeveloper(f.deleteRole))
+	mux.HandleFunc("GET /admin/devices/roles/{systemKey}/{name}", f.requireDeveloper(f.getDeviceRoles))
+	mux.HandleFunc("PUT /admin/devices/roles/{systemKey}/{name}", f.requireDeveloper(f.updateDeviceRoles))
+	mux.HandleFunc("GET /admin/systemmanagement/certificates", f.requireDevToken(f.getCACertificates))
+	mux.HandleFunc("POST /admin/systemmanagement/certificates", f.requireDevToken(f.addCACertificate))
+	f.start(t, mux)
+
+	return f
+}
+
+// Device returns a copy of a device, or nil if it does not exist.
+func (f *fakeEnterprise) Device(name string) map[string]interface{} {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	device, ok := f.devices[name]
+	if !ok {
+		return nil
+	}
+	return maps.Clone(device)
+}
+
+// DeviceCount returns the number of devices in the system.
+func (f *fakeEnterprise) DeviceCount() int {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	return len(f.devices)
+}
+
+// Keys returns the public keys of a device.
+func (f *fakeEnterprise) Keys(name string) []map[string]interface{} {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	return append([]map[string]interface{}{}, f.keys[name]...)
+}
+
+// Role returns a copy of a role, or nil if it does not exist.
+func (f *fakeEnterprise) Role(name string) *fakeEnterpriseRole {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	role, ok := f.roles[name]
+	if !ok {
+		return nil
+	}
+	return &fakeEnterpriseRole{
+		ID:          role.ID,
+		Name:        role.Name,
+		Topics:      maps.Clone(role.Topics),
+		Collections: maps.Clone(role.Collections),
+		Services:    maps.Clone(role.Services),
+		Devices:     role.Devices,
+	}
+}
+
+// CACertificates returns the root CA certificates of the system.
+func (f *fakeEnterprise) CACertificates() []string {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	return append([]string{}, f.caCerts...)
+}
+
+// RoleNames returns the names of every role, sorted.
+func (f *fakeEnterprise) RoleNames() []string {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	return slices.Sorted(maps.Keys(f.roles))
+}
+
+// DeviceRoles returns the roles of a device.
+func (f *fakeEnterprise) DeviceRoles(name string) []string {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	return append([]string{}, f.deviceRoles[name]...)
+}
+
+func (f *fakeEnterprise) authenticate(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body["email"] != fakeDevEmail || body["password"] != fakeDevPassword {
+		writeFakeError(w, http.StatusUnauthorized, "Invalid email or password")
+		return
+	}
+
+	writeFakeJSON(w, http.StatusOK, map[string]interface{}{"dev_token": fakeDevToken})
+}
+
+// requireDeveloper rejects requests without the developer token or for
+// another system.
+func (f *fakeEnterprise) requireDeveloper(next http.HandlerFunc) http.HandlerFunc {
+	return f.requireDevToken(func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("systemKey") != fakeSystemKey {
+			writeFakeError(w, http.StatusNotFound, fmt.Sprintf("System with key '%s' not found", r.PathValue("systemKey")))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// requireDevToken rejects requests without the developer token, for the
+// endpoints that take the system key as a parameter rather than in the path.
+func (f *fakeEnterprise) requireDevToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("ClearBlade-DevToken") != fakeDevToken {
+			writeFakeError(w, http.StatusUnauthorized, "Invalid developer token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (f *fakeEnterprise) createDevice(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	name := r.PathValue("name")
+	if _, ok := f.devices[name]; ok {
+		writeFakeError(w, http.StatusConflict, fmt.Sprintf("Device with name '%s' already exists in system", name))
+		return
+	}
+
+	body["name"] = name
+	body["system_key"] = fakeSystemKey
+	body["device_key"] = fakeSystemKey + " :: " + name
+	f.devices[name] = body
+	writeFakeJSON(w, http.StatusOK, body)
+}
+
+func (f *fakeEnterprise) updateDevice(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	name := r.PathValue("name")
+	device, ok := f.devices[name]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Device with name '%s' not found", name))
+		return
+	}
+
+	for column, value := range body {
+		if column != "name" {
+			device[column] = value
+		}
+	}
+	writeFakeJSON(w, http.StatusOK, device)
+}
+
+func (f *fakeEnterprise) addPublicKey(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	name := r.PathValue("name")
+	if _, ok := f.devices[name]; !ok {
+		writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Device with name '%s' not found", name))
+		return
+	}
+	if _, ok := body["public_key"].(string); !ok {
+		writeFakeError(w, http.StatusBadRequest, "public_key is required")
+		return
+	}
+
+	f.keys[name] = append(f.keys[name], body)
+	writeFakeJSON(w, http.StatusOK, body)
+}
+
+// deletePublicKeys removes every key of a device. The migration tool only
+// deletes with a query matching all keys, so the query is not evaluated.
+func (f *fakeEnterprise) deletePublicKeys(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("query") == "" {
+		writeFakeError(w, http.StatusBadRequest, "query is required")
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	deleted := f.keys[r.PathValue("name")]
+	delete(f.keys, r.PathValue("name"))
+	if deleted == nil {
+		deleted = []map[string]interface{}{}
+	}
+	writeFakeJSON(w, http.StatusOK, deleted)
+}
+
+func (f *fakeEnterprise) createRole(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name, _ := body["name"].(string)
+	if name == "" {
+		writeFakeError(w, http.StatusBadRequest, "A role must have a name")
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	// IoT Enterprise answers a duplicate role with a bad request, not a
+	// conflict
+	if _, ok := f.roles[name]; ok {
+		writeFakeError(w, http.StatusBadRequest, "A role's name must be unique")
+		return
+	}
+
+	f.roleSeq++
+	role := &fakeEnterpriseRole{
+		ID:          fmt.Sprintf("role-%d", f.roleSeq),
+		Name:        name,
+		Topics:      make(map[string]int),
+		Collections: make(map[string]int),
+		Services:    make(map[string]int),
+	}
+	f.roles[name] = role
+	writeFakeJSON(w, http.StatusOK, map[string]interface{}{"role_id": role.ID})
+}
+
+// getRoles answers role queries. Only the name filter sent by GetRole is
+// supported; any other query returns every role.
+func (f *fakeEnterprise) getRoles(w http.ResponseWriter, r *http.Request) {
+	name, err := queryName(r)
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	roles := make([]map[string]interface{}, 0)
+	for _, role := range f.roles {
+		if name == "" || role.Name == name {
+			roles = append(roles, role.apiRole())
+		}
+	}
+	writeFakeJSON(w, http.StatusOK, roles)
+}
+
+// apiRole returns the role in the layout of the roles endpoint, with its
+// permissions listed by kind.
+func (r *fakeEnterpriseRole) apiRole() map[string]interface{} {
+	topics := make([]map[string]interface{}, 0, len(r.Topics))
+	for topic, level := range r.Topics {
+		topics = append(topics, map[string]interface{}{"Name": topic, "Level": level})
+	}
+	collections := make([]map[string]interface{}, 0, len(r.Collections))
+	for collection, level := range r.Collections {
+		collections = append(collections, map[string]interface{}{"ID": collection, "Level": level})
+	}
+	services := make([]map[string]interface{}, 0, len(r.Services))
+	for service, level := range r.Services {
+		services = append(services, map[string]interface{}{"Name": service, "Level": level})
+	}
+
+	return map[string]interface{}{
+		"ID":   r.ID,
+		"Name": r.Name,
+		"Permissions": map[string]interface{}{
+			"Topics":      topics,
+			"Collections": collections,
+			"Services":    services,
+			"DevicesList": map[string]interface{}{"Name": "devices", "Level": r.Devices},
+		},
+	}
+}
+
+// fakeRoleChange is a permission change of a role update. Collections are
+// given by ID and topics and services by name.
+type fakeRoleChange struct {
+	ItemInfo struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"itemInfo"`
+	Permissions int `json:"permissions"`
+}
+
+// updateRole applies the topic, collection, service and devices table
+// changes of a role update.
+func (f *fakeEnterprise) updateRole(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ID      string `json:"id"`
+		Changes struct {
+			Topics      []fakeRoleChange `json:"topics"`
+			Collections []fakeRoleChange `json:"collections"`
+			Services    []fakeRoleChange `json:"services"`
+			Devices     *struct {
+				Permissions int `json:"permissions"`
+			} `json:"devices"`
+		} `json:"changes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	for _, role := range f.roles {
+		if role.ID != body.ID {
+			continue
+		}
+		for _, topic := range body.Changes.Topics {
+			role.Topics[topic.ItemInfo.Name] = topic.Permissions
+		}
+		for _, collection := range body.Changes.Collections {
+			role.Collections[collection.ItemInfo.ID] = collection.Permissions
+		}
+		for _, service := range body.Changes.Services {
+			role.Services[service.ItemInfo.Name] = service.Permissions
+		}
+		if body.Changes.Devices != nil {
+			role.Devices = body.Changes.Devices.Permissions
+		}
+		writeFakeJSON(w, http.StatusOK, map[string]interface{}{"role_id": role.ID})
+		return
+	}
+	writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Role with id '%s' not found", body.ID))
+}
+
+// deleteRole deletes the role given by the role query parameter, the way the
+// platform does, and takes it away from every device.
+func (f *fakeEnterprise) deleteRole(w http.ResponseWriter, r *http.Request) {
+	roleId := r.URL.Query().Get("role")
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	for name, role := range f.roles {
+		if role.ID != roleId {
+			continue
+		}
+		delete(f.roles, name)
+		for device, roles := range f.deviceRoles {
+			f.deviceRoles[device] = slices.DeleteFunc(roles, func(roleName string) bool { return roleName == name })
+		}
+		writeFakeJSON(w, http.StatusOK, map[string]interface{}{})
+		return
+	}
+	writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Role with id '%s' not found", roleId))
+}
+
+func (f *fakeEnterprise) updateDeviceRoles(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Add    []string `json:"add"`
+		Delete []string `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	name := r.PathValue("name")
+	if _, ok := f.devices[name]; !ok {
+		writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Device with name '%s' not found", name))
+		return
+	}
+
+	for _, roleName := range body.Add {
+		if _, ok := f.roles[roleName]; !ok {
+			writeFakeError(w, http.StatusNotFound, fmt.Sprintf("Role with name '%s' not found", roleName))
+			return
+		}
+		for _, existing := range f.deviceRoles[name] {
+			if existing == roleName {
+				writeFakeError(w, http.StatusInternalServerError, "pq: duplicate key value violates unique constraint \"roles_devices_pkey\"")
+				return
+			}
+		}
+	}
+
+	f.deviceRoles[name] = slices.DeleteFunc(f.deviceRoles[name], func(roleName string) bool { return slices.Contains(body.Delete, roleName) })
+	f.deviceRoles[name] = append(f.deviceRoles[name], body.Add...)
+	writeFakeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (f *fakeEnterprise) getDevices(w http.ResponseWriter, r *http.Request) {
+	name, err := queryName(r)
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	devices := make([]map[string]interface{}, 0)
+	for deviceName, device := range f.devices {
+		if name == "" || deviceName == name {
+			devices = append(devices, device)
+		}
+	}
+	writeFakeJSON(w, http.StatusOK, devices)
+}
+
+func (f *fakeEnterprise) getPublicKeys(w http.ResponseWriter, r *http.Request) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	keys := append([]map[string]interface{}{}, f.keys[r.PathValue("name")]...)
+	writeFakeJSON(w, http.StatusOK, keys)
+}
+
+func (f *fakeEnterprise) getDeviceRoles(w http.ResponseWriter, r *http.Request) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	roles := make([]map[string]interface{}, 0)
+	for _, role := range f.deviceRoles[r.PathValue("name")] {
+		roles = append(roles, map[string]interface{}{"Name": role})
+	}
+	writeFakeJSON(w, http.StatusOK, map[string]interface{}{"roles": roles})
+}
+
+// queryName returns the name a query filters on, or an empty string when the
+// query has no name filter.
+func queryName(r *http.Request) (string, error) {
+	var query struct {
+		Filters [][]map[string][]map[string]interface{} `json:"FILTERS"`
+	}
+	if q := r.URL.Query().Get("query"); q != "" {
+		if err := json.Unmarshal([]byte(q), &query); err != nil {
+			return "", err
+		}
+	}
+
+	for _, filters := range query.Filters {
+		for _, filter := range filters {
+			for _, eq := range filter["EQ"] {
+				if value, ok := eq["name"].(string); ok {
+					return value, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+func (f *fakeEnterprise) getCACertificates(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("system_key") != fakeSystemKey {
+		writeFakeError(w, http.StatusBadRequest, "Invalid system key")
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	certificates := make([]map[string]interface{}, 0, len(f.caCerts))
+	for i, certificate := range f.caCerts {
+		certificates = append(certificates, map[string]interface{}{"id": fmt.Sprint(i + 1), "certificate": certificate})
+	}
+	writeFakeJSON(w, http.StatusOK, certificates)
+}
+
+func (f *fakeEnterprise) addCACertificate(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	certificate, _ := body["certificate"].(string)
+	if body["system_key"] != fakeSystemKey || certificate == "" {
+		writeFakeError(w, http.StatusBadRequest, "A certificate and a system key are required")
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	f.caCerts = append(f.caCerts, certificate)
+	writeFakeJSON(w, http.StatusOK, map[string]interface{}{"id": fmt.Sprint(len(f.caCerts))})
+}
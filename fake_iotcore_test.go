@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+const (
+	fakeServiceAccountKey   = "service-account-system-key"
+	fakeServiceAccountToken = "service-account-token"
+	fakeRegistryKey         = "registry-system-key"
+	fakeRegistryToken       = "registry-token"
+)
+
+// fakeIotCore serves the ClearBlade IoT Core endpoints used by the migration
+// tool for a single registry: registry credentials, the device count, the
+// registry and its CA certificates, the device list and the device config versions and states.
+type fakeIotCore struct {
+	fakeServer
+
+	Project  string
+	Region   string
+	Registry string
+
+	stateMu     sync.Mutex
+	credentials []*cbiotcore.RegistryCredential
+	devices     []*cbiotcore.Device
+	configs     map[string][]*cbiotcore.DeviceConfig
+	states      map[string][]*cbiotcore.DeviceState
+	bindings    map[string][]string
+}
+
+func newFakeIotCore(t *testing.T) *fakeIotCore {
+	f := &fakeIotCore{
+		Project:  "test-project",
+		Region:   "us-central1",
+		Registry: "test-registry",
+		configs:  make(map[string][]*cbiotcore.DeviceConfig),
+		states:   make(map[string][]*cbiotcore.DeviceState),
+		bindings: make(map[string][]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v/1/code/{systemKey}/getRegistryCredentials", f.getRegistryCredentials)
+	mux.HandleFunc("POST /api/v/1/code/{systemKey}/getNumDevicesGateways", f.requireRegistry(f.getNumDevicesGateways))
+	mux.HandleFunc("GET /api/v/4/webhook/execute/{systemKey}/cloudiot", f.requireRegistry(f.getRegistry))
+	mux.HandleFunc("GET /api/v/4/webhook/execute/{systemKey}/cloudiot_devices", f.requireRegistry(f.listDevices))
+	mux.HandleFunc("GET /api/v/4/webhook/execute/{systemKey}/cloudiot_devices_configVersions", f.requireRegistry(f.listConfigVersions))
+	mux.HandleFunc("GET /api/v/4/webhook/execute/{systemKey}/cloudiot_devices_states", f.requireRegistry(f.listStates))
+	f.start(t, mux)
+
+	return f
+}
+
+// RegistryPath returns the resource name of the fake registry.
+func (f *fakeIotCore) RegistryPath() string {
+	return "projects/" + f.Project + "/locations/" + f.Region + "/registries/" + f.Registry
+}
+
+// AddCACertificates adds PEM encoded CA certificates to the registry
+// credentials.
+func (f *fakeIotCore) AddCACertificates(certificates ...string) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	for _, certificate := range certificates {
+		f.credentials = append(f.credentials, &cbiotcore.RegistryCredential{
+			PublicKeyCertificate: &cbiotcore.PublicKeyCertificate{Format: "X509_CERTIFICATE_PEM", Certificate: certificate},
+		})
+	}
+}
+
+// AddDevices adds devices to the registry, in list order.
+func (f *fakeIotCore) AddDevices(devices ...*cbiotcore.Device) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.devices = append(f.devices, devices...)
+}
+
+// AddConfig adds a config version to a device. The latest version goes first.
+func (f *fakeIotCore) AddConfig(deviceId string, config *cbiotcore.DeviceConfig) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.configs[deviceId] = append([]*cbiotcore.DeviceConfig{config}, f.configs[deviceId]...)
+}
+
+// AddState adds a state to a device. The latest state goes first.
+func (f *fakeIotCore) AddState(deviceId string, state *cbiotcore.DeviceState) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.states[deviceId] = append([]*cbiotcore.DeviceState{state}, f.states[deviceId]...)
+}
+
+// Bind associates a device with a gateway.
+func (f *fakeIotCore) Bind(gatewayId, deviceId string) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	f.bindings[gatewayId] = append(f.bindings[gatewayId], deviceId)
+}
+
+// WriteServiceAccount writes a service account file for the fake to dir and
+// returns its path.
+func (f *fakeIotCore) WriteServiceAccount(t *testing.T, dir string) string {
+	content, err := json.Marshal(map[string]string{
+		"systemKey": fakeServiceAccountKey,
+		"token":     fakeServiceAccountToken,
+		"url":       f.URL,
+		"project":   f.Project,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serviceAccount := filepath.Join(dir, "service-account.json")
+	if err := os.WriteFile(serviceAccount, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return serviceAccount
+}
+
+func (f *fakeIotCore) getRegistryCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("systemKey") != fakeServiceAccountKey || r.Header.Get("ClearBlade-UserToken") != fakeServiceAccountToken {
+		writeFakeError(w, http.StatusUnauthorized, "Invalid service account token")
+		return
+	}
+
+	var body map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body["project"] != f.Project || body["region"] != f.Region || body["registry"] != f.Registry {
+		writeFakeError(w, http.StatusNotFound, "Registry not found")
+		return
+	}
+
+	writeFakeJSON(w, http.StatusOK, &cbiotcore.RegistryUserCredentials{
+		SystemKey: fakeRegistryKey,
+		Token:     fakeRegistryToken,
+		Url:       f.URL,
+	})
+}
+
+// requireRegistry rejects requests that do not use the registry credentials.
+func (f *fakeIotCore) requireRegistry(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("systemKey") != fakeRegistryKey || r.Header.Get("ClearBlade-UserToken") != fakeRegistryToken {
+			writeFakeError(w, http.StatusUnauthorized, "Invalid registry token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (f *fakeIotCore) getNumDevicesGateways(w http.ResponseWriter, r *http.Request) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	gateways := 0
+	for _, device := range f.devices {
+		if device.GatewayConfig != nil && device.GatewayConfig.GatewayType == "GATEWAY" {
+			gateways++
+		}
+	}
+
+	writeFakeJSON(w, http.StatusOK, map[string]interface{}{
+		"counts": map[string]int{
+			"devices":  len(f.devices),
+			"gateways": gateways,
+		},
+	})
+}
+
+// getRegistry answers registry gets. The registry is identified by the
+// registry credentials, as the SDK does not send its name.
+func (f *fakeIotCore) getRegistry(w http.ResponseWriter, r *http.Request) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	writeFakeJSON(w, http.StatusOK, &cbiotcore.DeviceRegistry{
+		Id:          f.Registry,
+		Name:        f.RegistryPath(),
+		Credentials: f.credentials,
+	})
+}
+
+func (f *fakeIotCore) listDevices(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("parent") != f.RegistryPath() {
+		writeFakeError(w, http.StatusNotFound, "Registry not found")
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	devices := f.devices
+	if ids := query["deviceIds"]; len(ids) > 0 {
+		devices = filterFakeDevices(devices, ids)
+	}
+	if gatewayId := query.Get("gatewayListOptions.associationsGatewayId"); gatewayId != "" {
+		devices = filterFakeDevices(devices, f.bindings[gatewayId])
+	}
+
+	// Page tokens are the index of the first device of the page
+	start := 0
+	if token := query.Get("pageToken"); token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil || start > len(devices) {
+			writeFakeError(w, http.StatusBadRequest, "Invalid page token")
+			return
+		}
+	}
+
+	end := len(devices)
+	nextPageToken := ""
+	if pageSize, _ := strconv.Atoi(query.Get("pageSize")); pageSize > 0 && start+pageSize < len(devices) {
+		end = start + pageSize
+		nextPageToken = strconv.Itoa(end)
+	}
+
+	writeFakeJSON(w, http.StatusOK, &cbiotcore.ListDevicesResponse{
+		Devices:       devices[start:end],
+		NextPageToken: nextPageToken,
+	})
+}
+
+func (f *fakeIotCore) listConfigVersions(w http.ResponseWriter, r *http.Request) {
+	deviceId, ok := f.deviceIdFromName(r.URL.Query().Get("name"))
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+
+	configs := f.configs[deviceId]
+	if numVersions, _ := strconv.Atoi(r.URL.Query().Get("numVersions")); numVersions > 0 && numVersions < len(configs) {
+		configs = configs[:numVersions]
+	}
+	writeFakeJSON(w, http.StatusOK, &cbiotcore.ListDeviceConfigVersionsResponse{DeviceConfigs: configs})
+}
+
+func (f *fakeIotCore) listStates(w http.ResponseWriter, r *http.Request) {
+	deviceId, ok := f.deviceIdFromName(r.URL.Query().Get("name"))
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	writeFakeJSON(w, http.StatusOK, &cbiotcore.ListDeviceStatesResponse{DeviceStates: f.states[deviceId]})
+}
+
+func (f *fakeIotCore) deviceIdFromName(name string) (string, bool) {
+	prefix := f.RegistryPath() + "/devices/"
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, prefix), true
+}
+
+// filterFakeDevices returns the devices with the given IDs in registry order.
+func filterFakeDevices(devices []*cbiotcore.Device, ids []string) []*cbiotcore.Device {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	filtered := make([]*cbiotcore.Device, 0, len(ids))
+	for _, device := range devices {
+		if wanted[device.Id] {
+			filtered = append(filtered, device)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fault makes a fake server misbehave for the requests it matches.
+type fault struct {
+	// Method matches the request method. Empty matches every method.
+	Method string
+
+	// Path is a path.Match pattern matched against the request path, such as
+	// "/admin/devices/*/device-2".
+	Path string
+
+	// Latency delays the matching requests before they are answered.
+	Latency time.Duration
+
+	// Status is returned instead of the real response. Zero only adds latency.
+	Status int
+
+	// Body is returned with Status. It defaults to a ClearBlade style error.
+	Body string
+
+	// Times is how many matching requests fail. Zero fails every request.
+	Times int
+
+	hits int
+}
+
+// fakeServer is the part shared by the fake IoT Core and IoT Enterprise
+// servers. It records every request and applies the injected faults before
+// the real handler runs.
+type fakeServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	faults   []*fault
+	requests []fakeRequest
+}
+
+type fakeRequest struct {
+	method string
+	path   string
+}
+
+func (s *fakeServer) start(t *testing.T, handler http.Handler) {
+	s.Server = httptest.NewServer(s.wrap(handler))
+	t.Cleanup(s.Close)
+}
+
+// Inject adds a fault. Faults are matched in the order they were added.
+func (s *fakeServer) Inject(f fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// Requests returns the number of requests received for the method and path
+// pattern, whether they failed or not.
+func (s *fakeServer) Requests(method, pattern string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, request := range s.requests {
+		if request.method == method && matchPath(pattern, request.path) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *fakeServer) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := s.match(r)
+		if f == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if f.Latency > 0 {
+			time.Sleep(f.Latency)
+		}
+		if f.Status == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if f.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		if f.Body != "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(f.Status)
+			_, _ = w.Write([]byte(f.Body))
+			return
+		}
+		writeFakeError(w, f.Status, http.StatusText(f.Status))
+	})
+}
+
+// match records the request and returns the first fault that applies to it.
+func (s *fakeServer) match(r *http.Request) *fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, fakeRequest{method: r.Method, path: r.URL.Path})
+	for _, f := range s.faults {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if !matchPath(f.Path, r.URL.Path) {
+			continue
+		}
+		if f.Times > 0 && f.hits >= f.Times {
+			continue
+		}
+		f.hits++
+		return f
+	}
+	return nil
+}
+
+func matchPath(pattern, requestPath string) bool {
+	ok, err := path.Match(pattern, requestPath)
+	return err == nil && ok
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeFakeError answers with the error layout used by the ClearBlade platform.
+func writeFakeError(w http.ResponseWriter, status int, message string) {
+	writeFakeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": message,
+			"status":  strconv.Itoa(status),
+		},
+	})
+}
@@ -0,0 +1,20 @@
+package main
+
+import "clearblade-iot-enterprise-migration/migrator"
+
+// runImport migrates the devices of an export archive. It is a shorthand for
+// running a migration with -sourceType archive.
+func runImport(arguments []string) int {
+	args := &cliArgs{}
+
+	fs := newFlagSet("import")
+	fs.StringVar(&args.SourcePath, "archive", "", "Directory of an archive created by the export command (Required)")
+	initEnterpriseFlags(fs, args)
+	initOptionalFlags(fs, args)
+	initLogFlags(fs, args)
+	_ = fs.Parse(arguments)
+
+	args.SourceType = migrator.SourceTypeArchive
+	args.TargetType = migrator.TargetTypeEnterprise
+	return runMigration(args)
+}
@@ -0,0 +1,19 @@
+package main
+
+// runKeys replaces the public keys of every migrated device, without touching
+// the devices or their roles.
+func runKeys(arguments []string) int {
+	args := &cliArgs{}
+	fs := newFlagSet("keys")
+	initMigrationFlags(fs, args)
+	_ = fs.Parse(arguments)
+	defer args.closeLog()
+
+	m, err := connectMigrator(args)
+	if err != nil {
+		return printError(err)
+	}
+
+	result, err := m.MigrateKeys()
+	return finishMigration(m, result, err)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// secretAttrKeys are the log attribute keys whose values are never written.
+// Keys are compared in lower case and match when they contain any of these.
+var secretAttrKeys = []string{"password", "pwd", "secret", "token", "authorization"}
+
+func initLogFlags(fs *flag.FlagSet, args *cliArgs) {
+	fs.StringVar(&args.logFile, "logFile", "", "Path of a file structured logs are appended to. Nothing is logged when empty")
+	fs.StringVar(&args.logLevel, "logLevel", "info", "Minimum level of logged records: debug, info, warn or error. Default is info")
+	fs.StringVar(&args.logFormat, "logFormat", "text", "Format of logged records: text or json. Default is text")
+}
+
+// openLog creates the logger given by the log flags and passes it to the
+// migrator. Secrets given on the command line are redacted from every record.
+// The level and format are checked even when no log file is given.
+func (args *cliArgs) openLog() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(args.logLevel)); err != nil {
+		return fmt.Errorf("invalid log level %q. Supported levels are debug, info, warn and error", args.logLevel)
+	}
+	if args.logFormat != "text" && args.logFormat != "json" {
+		return fmt.Errorf("invalid log format %q. Supported formats are text and json", args.logFormat)
+	}
+
+	if args.logFile == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(args.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open log file: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr([]string{args.DevPassword, args.SystemSecret}),
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(f, opts)
+	if args.logFormat == "json" {
+		handler = slog.NewJSONHandler(f, opts)
+	}
+
+	args.log = f
+	args.Logger = slog.New(handler)
+	return nil
+}
+
+// closeLog closes the log file, if any.
+func (args *cliArgs) closeLog() {
+	if args.log != nil {
+		_ = args.log.Close()
+		args.log = nil
+	}
+}
+
+// redactAttr returns a slog.HandlerOptions.ReplaceAttr function that hides the
+// value of attributes with a secret key, and replaces every occurrence of the
+// given secrets in the other attributes, including the message and errors.
+func redactAttr(secrets []string) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		key := strings.ToLower(a.Key)
+		for _, secretKey := range secretAttrKeys {
+			if strings.Contains(key, secretKey) {
+				return slog.String(a.Key, redacted)
+			}
+		}
+
+		var value string
+		switch v := a.Value.Resolve().Any().(type) {
+		case string:
+			value = v
+		case error:
+			value = v.Error()
+		default:
+			return a
+		}
+
+		for _, secret := range secrets {
+			if secret != "" {
+				value = strings.ReplaceAll(value, secret, redacted)
+			}
+		}
+		return slog.String(a.Key, value)
+	}
+}
@@ -1,25 +1,16 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 
-	cb "github.com/clearblade/Go-SDK"
-	cbiotcore "github.com/clearblade/go-iot"
-)
-
-const TotalWorkers = 10
-
-var (
-	Args           DeviceMigratorArgs
-	cbCtx          context.Context
-	iotCoreService *cbiotcore.Service
-	cbDevClient    *cb.DevClient
+	"clearblade-iot-enterprise-migration/migrator"
 )
 
 var (
@@ -30,66 +21,92 @@ var (
 	colorRed    = "\033[31m"
 )
 
-type DeviceMigratorArgs struct {
-	// ClearBlade IoT Core specific flags
-	cbServiceAccount string
-	cbRegistryName   string
-	cbRegistryRegion string
+// cliArgs holds the command line flags. Everything but silentMode and the log
+// flags is passed on to the migrator.
+type cliArgs struct {
+	migrator.Options
+	silentMode bool
 
-	//CB Enterprise Flags
-	cbEnterpriseUrl    string
-	cbEnterpriseMsgUrl string
-	cbSystemKey        string
-	cbSystemSecret     string
-	cbDevEmail         string
-	cbDevPwd           string
-
-	// Optional flags
-	devicesCsvFile   string
-	columnsCsvFile   string
-	deviceType       string
-	pageSize         int
-	updatePublicKeys bool
-	silentMode       bool
-	createDeviceRole bool
+	logFile   string
+	logLevel  string
+	logFormat string
+	log       *os.File
+}
+
+func initMigrationFlags(fs *flag.FlagSet, args *cliArgs) {
+	initSourceFlags(fs, args)
+	initTargetFlags(fs, args)
+	initCbIotCoreFlags(fs, args)
+	initEnterpriseFlags(fs, args)
+	initOptionalFlags(fs, args)
+	initLogFlags(fs, args)
 }
 
-func initMigrationFlags() {
+func initSourceFlags(fs *flag.FlagSet, args *cliArgs) {
+	fs.StringVar(&args.SourceType, "sourceType", migrator.SourceTypeIotCore, "Where devices are migrated from: iotcore, archive (a directory created by the export command) or inventory (a CSV, JSON or NDJSON device inventory file). Default is iotcore")
+	fs.StringVar(&args.SourcePath, "sourcePath", "", "Path of the archive or inventory to migrate devices from (Required for the archive and inventory source types)")
+}
+
+func initTargetFlags(fs *flag.FlagSet, args *cliArgs) {
+	fs.StringVar(&args.TargetType, "targetType", migrator.TargetTypeEnterprise, "Where devices are migrated to: enterprise (the IoT Enterprise system given by the cbEnterprise flags) or file (a local JSON file, to rehearse a migration). Default is enterprise")
+	fs.StringVar(&args.TargetPath, "targetPath", "", "Path of the JSON file devices are written to (Required for the file target type)")
+}
+
+func initCbIotCoreFlags(fs *flag.FlagSet, args *cliArgs) {
 	//CB IoT Core Flags
-	flag.StringVar(&Args.cbServiceAccount, "cbServiceAccount", "", "Path to a ClearBlade service account file. See https://clearblade.atlassian.net/wiki/spaces/IC/pages/2240675843/Add+service+accounts+to+a+project (Required)")
-	flag.StringVar(&Args.cbRegistryName, "cbRegistryName", "", "ClearBlade Registry Name (Required)")
-	flag.StringVar(&Args.cbRegistryRegion, "cbRegistryRegion", "", "ClearBlade Registry Region (Required)")
+	fs.StringVar(&args.ServiceAccount, "cbServiceAccount", "", "Path to a ClearBlade service account file. See https://clearblade.atlassian.net/wiki/spaces/IC/pages/2240675843/Add+service+accounts+to+a+project (Required)")
+	fs.StringVar(&args.RegistryName, "cbRegistryName", "", "ClearBlade Registry Name (Required)")
+	fs.StringVar(&args.RegistryRegion, "cbRegistryRegion", "", "ClearBlade Registry Region (Required)")
+}
 
+func initEnterpriseFlags(fs *flag.FlagSet, args *cliArgs) {
 	//CB Enterprise Flags
-	flag.StringVar(&Args.cbEnterpriseUrl, "cbEnterpriseUrl", "", "ClearBlade IoT Enterprise Url (Required)")
-	flag.StringVar(&Args.cbEnterpriseMsgUrl, "cbEnterpriseMsgUrl", "", "ClearBlade IoT Enterprise Messaging Url (Required)")
-	flag.StringVar(&Args.cbSystemKey, "cbSystemKey", "", "ClearBlade IoT Enterprise System Key (Required)")
-	flag.StringVar(&Args.cbSystemSecret, "cbSystemSecret", "", "ClearBlade IoT Enterprise System Secret (Required)")
-	flag.StringVar(&Args.cbDevEmail, "cbDevEmail", "", "ClearBlade IoT Enterprise developer e-mail (Required)")
-	flag.StringVar(&Args.cbDevPwd, "cbDevPwd", "", "ClearBlade IoT Enterprise developer password (Required)")
+	fs.StringVar(&args.EnterpriseUrl, "cbEnterpriseUrl", "", "ClearBlade IoT Enterprise Url (Required)")
+	fs.StringVar(&args.EnterpriseMsgUrl, "cbEnterpriseMsgUrl", "", "ClearBlade IoT Enterprise Messaging Url (Required)")
+	fs.StringVar(&args.SystemKey, "cbSystemKey", "", "ClearBlade IoT Enterprise System Key (Required)")
+	fs.StringVar(&args.SystemSecret, "cbSystemSecret", "", "ClearBlade IoT Enterprise System Secret (Required)")
+	fs.StringVar(&args.DevEmail, "cbDevEmail", "", "ClearBlade IoT Enterprise developer e-mail (Required)")
+	fs.StringVar(&args.DevPassword, "cbDevPwd", "", "ClearBlade IoT Enterprise developer password (Required)")
+}
 
+func initOptionalFlags(fs *flag.FlagSet, args *cliArgs) {
 	// Optional
-	flag.StringVar(&Args.devicesCsvFile, "devicesCsv", "", "Devices CSV file path")
-	flag.StringVar(&Args.columnsCsvFile, "columnMapCsv", "", "Column Map CSV file path")
-	flag.StringVar(&Args.deviceType, "deviceType", "", "Device type")
-	flag.IntVar(&Args.pageSize, "pageSize", 100, "Page Size")
-	flag.BoolVar(&Args.updatePublicKeys, "updatePublicKeys", true, "Replace existing keys of migrated devices. Default is true")
-	flag.BoolVar(&Args.silentMode, "silentMode", false, "Run this tool in silent (non-interactive) mode. Default is false")
-	flag.BoolVar(&Args.createDeviceRole, "createDeviceRole", false, "Should the device roles and permissions be created")
+	fs.StringVar(&args.DevicesFile, "devicesCsv", "", "Devices list file path (CSV, JSON array or NDJSON). Use - to read the list from stdin")
+	fs.StringVar(&args.ColumnMapFile, "columnMapCsv", "", "Column Map CSV file path")
+	fs.StringVar(&args.DeviceType, "deviceType", "", "Device type")
+	fs.IntVar(&args.PageSize, "pageSize", 100, "Page Size")
+	fs.IntVar(&args.FetchWorkers, "fetchWorkers", 5, "Number of concurrent device list requests when fetching devices from a CSV file. Default is 5")
+	fs.BoolVar(&args.UpdatePublicKeys, "updatePublicKeys", true, "Replace existing keys of migrated devices. Default is true")
+	fs.BoolVar(&args.silentMode, "silentMode", false, "Run this tool in silent (non-interactive) mode. Default is false")
+	fs.BoolVar(&args.CreateDeviceRole, "createDeviceRole", false, "Should the device roles and permissions be created")
+	fs.StringVar(&args.RoleStrategy, "roleStrategy", migrator.RoleStrategyDevice, "Which role devices are assigned: device (a role per device), shared (one role for every device) or type (one role per device type). Default is device")
+	fs.StringVar(&args.SharedRoleName, "sharedRoleName", migrator.DefaultSharedRoleName, "Name of the shared device role, and prefix of the device type roles. Default is "+migrator.DefaultSharedRoleName)
+	fs.BoolVar(&args.ConsolidateRoles, "consolidateRoles", false, "Remove and delete the per-device roles of devices assigned a shared or device type role. Requires -allowSharedDeviceAccess. Default is false")
+	fs.BoolVar(&args.AllowSharedDeviceAccess, "allowSharedDeviceAccess", false, "Let shared and device type roles render device tokens as wildcards, giving every device the topics of every other device. Also required by devices permissions in the role configuration. Default is false")
+	fs.StringVar(&args.RoleConfigFile, "roleConfig", "", "JSON file with the topic templates and permissions of the device roles. Default is the ClearBlade IoT Core topic layout")
+	fs.BoolVar(&args.MigrateCACertificates, "migrateCaCertificates", false, "Add the CA certificates of the registry to the IoT Enterprise system before migrating the devices, so devices with X.509 certificates issued by them can authenticate. Default is false")
+	initExpiredCredentialsFlag(fs, args)
+	initDeviceAuthFlags(fs, args)
 }
 
-func main() {
-	// Init & Parse migration Flags
-	initMigrationFlags()
-	flag.Parse()
+func initDeviceAuthFlags(fs *flag.FlagSet, args *cliArgs) {
+	fs.BoolVar(&args.AllowKeyAuth, "allowKeyAuth", false, "Allow migrated devices to authenticate with their active key. Default is false")
+	fs.BoolFunc("allowCertificateAuth", "Allow migrated devices to authenticate with their certificates and public keys. Default is true", func(value string) error {
+		allow, err := strconv.ParseBool(value)
+		args.DisableCertificateAuth = !allow
+		return err
+	})
+	fs.BoolVar(&args.GenerateActiveKeys, "generateActiveKeys", false, "Generate a random active key, and allow key auth, for every created device without credentials. The keys are written to an active_keys CSV file. Default is false")
+	fs.StringVar(&args.DeviceAuthRulesFile, "deviceAuthRules", "", "JSON file of rules setting allowKeyAuth, allowCertificateAuth and generateActiveKey per device")
+}
 
-	if len(os.Args) == 1 {
-		log.Fatalln("No flags supplied. Use clearblade-iot-enterprise-migration --help to view details.")
-	}
+func initExpiredCredentialsFlag(fs *flag.FlagSet, args *cliArgs) {
+	fs.StringVar(&args.ExpiredCredentials, "expiredCredentials", migrator.ExpiredCredentialsMigrate, "What to do with device keys whose expiration time or certificate expiry has passed: migrate (upload them anyway), skip (leave them out) or fail (leave them out and fail the device). Default is migrate")
+}
 
-	if os.Args[1] == "version" {
-		fmt.Printf("%s\n", cbIotEnterpriseMigrationVersion)
-		os.Exit(0)
+func main() {
+	if len(os.Args) == 1 {
+		fatalConfig("No command or flags supplied. Use clearblade-iot-enterprise-migration help to view details.")
 	}
 
 	if runtime.GOOS == "windows" {
@@ -100,222 +117,318 @@ func main() {
 		colorRed = ""
 	}
 
-	// Validate if all required CB flags are provided
-	validateCBFlags()
-	validateEnterpriseFlags()
+	name, arguments := os.Args[1], os.Args[2:]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return
+	}
 
-	fmt.Println(string(colorGreen), "\n\u2713 All Flags validated!", string(colorReset))
+	// Flags without a command run a migration, as before subcommands existed
+	if strings.HasPrefix(name, "-") {
+		name, arguments = "migrate", os.Args[1:]
+	}
 
-	//Create the ClearBlade IoT Core services
-	var err error
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(exitConfigError)
+	}
+	os.Exit(cmd.run(arguments))
+}
+
+func runMigrate(arguments []string) int {
+	args := &cliArgs{}
+	fs := newFlagSet("migrate")
+	initMigrationFlags(fs, args)
+	_ = fs.Parse(arguments)
+
+	return runMigration(args)
+}
+
+// runMigration validates the migration flags, connects to the device source
+// and the target IoT Enterprise system, and migrates the devices. It returns
+// the exit code of the run.
+func runMigration(args *cliArgs) int {
+	defer args.closeLog()
 
-	cbCtx = context.Background()
-	iotCoreService, err = cbiotcore.NewService(cbCtx)
+	m, err := connectMigrator(args)
 	if err != nil {
-		fmt.Println(string(colorRed), "\n\u2715 Error creating IoT core service interface: %s", err.Error(), string(colorReset))
-		os.Exit(0)
+		return printError(err)
 	}
 
-	// GetRegistryCredentials
-	regDetails, err := cbiotcore.GetRegistryCredentials(Args.cbRegistryName, Args.cbRegistryRegion, iotCoreService)
-	if err != nil {
-		fmt.Println(string(colorRed), "\n\u2715 Error retrieving registry credentials: %s! Please check if -cbRegistryName and/or -cbRegistryRegion flags are set correctly.", err.Error(), string(colorReset))
-		os.Exit(0)
+	result, err := m.Migrate()
+	return finishMigration(m, result, err)
+}
+
+// connectMigrator validates the migration flags, prompting for missing values
+// unless running in silent mode, and connects to the device source and the
+// target.
+func connectMigrator(args *cliArgs) (*migrator.Migrator, error) {
+	// Stdin carries the device list, so it cannot be used for interactive prompts
+	if args.DevicesFile == migrator.StdinDeviceList {
+		args.silentMode = true
+	}
+
+	// Validate if all required CB flags are provided
+	switch args.SourceType {
+	case migrator.SourceTypeIotCore:
+		validateCBFlags(args)
+	case migrator.SourceTypeArchive, migrator.SourceTypeInventory:
+		validateSourcePath(args)
+	}
+	validateOptionalFlags(args)
+	if args.TargetType == migrator.TargetTypeEnterprise {
+		validateEnterpriseFlags(args)
 	}
 
-	if regDetails.SystemKey == "" {
-		fmt.Println(string(colorRed), "\n\u2715 Unable to fetch ClearBlade registry Details! Please check if -cbRegistryName and/or -cbRegistryRegion flags are set correctly.", string(colorReset))
-		os.Exit(0)
+	if err := args.openLog(); err != nil {
+		fatalConfig("Invalid log flags: ", err)
 	}
 
-	// Authenticate Clearblade User account
-	cbDevClient, err = authenticateCbEnterprise(&Args)
+	m, err := migrator.New(args.Options)
 	if err != nil {
-		fmt.Println(string(colorRed), "\n\u2715 Error authenticating with ClearBlade IoT Enterprise: %s", err.Error(), string(colorReset))
-		os.Exit(0)
+		return nil, err
 	}
 
-	//GetDeviceCount
-	deviceCount, err := getDeviceCount(regDetails, Args.cbRegistryName, Args.cbRegistryRegion, iotCoreService)
+	fmt.Println(string(colorGreen), "\n\u2713 All Flags validated!", string(colorReset))
+
+	// Connect to the device source and authenticate the ClearBlade user account
+	if err := m.Connect(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// finishMigration closes the migration target, writes the failed devices of a
+// migration run to a CSV file and returns the exit code of the run.
+func finishMigration(m *migrator.Migrator, result *migrator.Result, err error) int {
 	if err != nil {
-		fmt.Println(string(colorRed), "\n\u2715 Error retrieving registry device count: %s!", err.Error(), string(colorReset))
-		os.Exit(0)
+		_ = m.Close()
+		// The source can fail part way, so keep a record of the devices that
+		// were attempted and failed before it did
+		if result != nil && len(result.Failed) > 0 {
+			if csvErr := generateFailedDevicesCSV(result.Failed); csvErr != nil {
+				log.Println(csvErr)
+			}
+		}
+		if result != nil {
+			if activeKeys := result.ActiveKeys(); len(activeKeys) > 0 {
+				if csvErr := generateActiveKeysCSV(activeKeys); csvErr != nil {
+					log.Println(csvErr)
+				}
+			}
+		}
+		return printError(err)
+	}
+
+	if err := m.Close(); err != nil {
+		log.Println("Unable to close migration target: ", err)
+		return exitFailure
+	}
+
+	failedCertificates := result.FailedCACertificates()
+	if failedCertificates > 0 {
+		fmt.Printf("%sWarning: %d registry CA certificates failed to migrate\n%s", string(colorYellow), failedCertificates, string(colorReset))
 	}
 
-	if deviceCount > 0 {
-		migrateDevices(deviceCount)
-	} else {
-		fmt.Println(string(colorRed), "\n\n\u2715 No devices in registry. Skipping migration.", string(colorReset))
+	if result.Devices == 0 && len(result.MissingIds) == 0 && failedCertificates == 0 {
+		return exitSuccess
 	}
+
+	// Generated keys are written even when the migration fails, as the
+	// devices were created with them
+	if activeKeys := result.ActiveKeys(); len(activeKeys) > 0 {
+		if err := generateActiveKeysCSV(activeKeys); err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+	}
+
+	if keyless := result.KeylessDevices(); len(keyless) > 0 {
+		fmt.Printf("%sNote: the following devices have no credentials and can only connect with an active key - %s\n%s", string(colorYellow), strings.Join(keyless, ", "), string(colorReset))
+	}
+
+	if len(result.Failed) > 0 {
+		fmt.Println("Invoking generateFailedDevicesCSV")
+		if err := generateFailedDevicesCSV(result.Failed); err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+	}
+
+	fmt.Println(string(colorGreen), "\n\n\u2713 Done!", string(colorReset))
+
+	if len(result.Failed) > 0 || len(result.MissingIds) > 0 || failedCertificates > 0 {
+		return exitPartialFailure
+	}
+	return exitSuccess
 }
 
-func validateCBFlags() {
+func validateCBFlags(args *cliArgs) {
 
-	if Args.cbServiceAccount == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbServiceAccount is a required paramter")
+	if args.ServiceAccount == "" {
+		if args.silentMode {
+			fatalConfig("-cbServiceAccount is a required paramter")
 		}
 
 		value, err := readInput("Enter path to ClearBlade service account file. See https://clearblade.atlassian.net/wiki/spaces/IC/pages/2240675843/Add+service+accounts+to+a+project for more info: ")
 		if err != nil {
-			log.Fatalln("Error reading service account: ", err)
+			fatalConfig("Error reading service account: ", err)
 		}
-		Args.cbServiceAccount = value
+		args.ServiceAccount = value
 	}
 
 	// validate that path to service account file exists
-	if _, err := os.Stat(Args.cbServiceAccount); errors.Is(err, os.ErrNotExist) {
-		log.Fatalf("Could not locate service account file %s. Please make sure the path is correct\n", Args.cbServiceAccount)
-	}
-
-	err := os.Setenv("CLEARBLADE_CONFIGURATION", Args.cbServiceAccount)
-	if err != nil {
-		log.Fatalln("Failed to set CLEARBLADE_CONFIGURATION env variable", err.Error())
+	if _, err := os.Stat(args.ServiceAccount); errors.Is(err, os.ErrNotExist) {
+		fatalConfig(fmt.Sprintf("Could not locate service account file %s. Please make sure the path is correct", args.ServiceAccount))
 	}
 
-	if Args.cbRegistryName == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbRegistryName is required parameter")
+	if args.RegistryName == "" {
+		if args.silentMode {
+			fatalConfig("-cbRegistryName is required parameter")
 		}
 		value, err := readInput("Enter ClearBlade Registry Name: ")
 		if err != nil {
-			log.Fatalln("Error reading registry name: ", err)
+			fatalConfig("Error reading registry name: ", err)
 		}
-		Args.cbRegistryName = value
+		args.RegistryName = value
 	}
 
-	if Args.cbRegistryRegion == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbRegistryRegion is required parameter")
+	if args.RegistryRegion == "" {
+		if args.silentMode {
+			fatalConfig("-cbRegistryRegion is required parameter")
 		}
 		value, err := readInput("Enter ClearBlade Registry Region: ")
 		if err != nil {
-			log.Fatalln("Error reading ClearBlade registry region: ", err)
+			fatalConfig("Error reading ClearBlade registry region: ", err)
 		}
 
-		Args.cbRegistryRegion = value
+		args.RegistryRegion = value
 	}
+}
 
-	if Args.devicesCsvFile == "" {
-		if Args.silentMode {
+func validateOptionalFlags(args *cliArgs) {
+	if args.DevicesFile == "" && len(args.DeviceIds) == 0 {
+		if args.silentMode {
 			return
 		}
 		value, err := readInput("Enter Devices CSV file path (By default all devices from the registry will be migrated. Press enter to skip!): ")
 		if err != nil {
-			log.Fatalln("Error reading service account file path: ", err)
+			fatalConfig("Error reading service account file path: ", err)
+		}
+		args.DevicesFile = value
+	}
+
+	if args.ColumnMapFile == "" {
+		if args.silentMode {
+			return
+		}
+		value, err := readInput("Enter the path to a CSV file containing column mappings (Press enter to skip!): ")
+		if err != nil {
+			fatalConfig("Error reading column map CSV file path: ", err)
 		}
-		Args.devicesCsvFile = value
+		args.ColumnMapFile = value
+	}
+
+	if args.DeviceType == "" {
+		if args.silentMode {
+			return
+		}
+		value, err := readInput("Enter the device type to assign to each migrated device (Press enter to skip!): ")
+		if err != nil {
+			fatalConfig("Error reading device type: ", err)
+		}
+		args.DeviceType = value
 	}
 }
 
-func validateEnterpriseFlags() {
-	if Args.cbEnterpriseUrl == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbEnterpriseUrl is a required paramter")
+func validateEnterpriseFlags(args *cliArgs) {
+	if args.EnterpriseUrl == "" {
+		if args.silentMode {
+			fatalConfig("-cbEnterpriseUrl is a required paramter")
 		}
 
 		value, err := readInput("Enter the URL of the IoT Enterprise instance the devices will be migrated to: ")
 		if err != nil {
-			log.Fatalln("Error reading IoT Enterprise URL: ", err)
+			fatalConfig("Error reading IoT Enterprise URL: ", err)
 		}
-		Args.cbEnterpriseUrl = value
+		args.EnterpriseUrl = value
 	}
 
-	if Args.cbEnterpriseMsgUrl == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbEnterpriseMsgUrl is a required paramter")
+	if args.EnterpriseMsgUrl == "" {
+		if args.silentMode {
+			fatalConfig("-cbEnterpriseMsgUrl is a required paramter")
 		}
 
 		value, err := readInput("Enter the messaging URL of the IoT Enterprise instance the devices will be migrated to: ")
 		if err != nil {
-			log.Fatalln("Error reading IoT Enterprise messaging url: ", err)
+			fatalConfig("Error reading IoT Enterprise messaging url: ", err)
 		}
-		Args.cbEnterpriseMsgUrl = value
+		args.EnterpriseMsgUrl = value
 	}
 
-	if Args.cbSystemKey == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbSystemKey is a required paramter")
+	if args.SystemKey == "" {
+		if args.silentMode {
+			fatalConfig("-cbSystemKey is a required paramter")
 		}
 
 		value, err := readInput("Enter the system key of the IoT Enterprise system the devices will be migrated to: ")
 		if err != nil {
-			log.Fatalln("Error reading system key: ", err)
+			fatalConfig("Error reading system key: ", err)
 		}
-		Args.cbSystemKey = value
+		args.SystemKey = value
 	}
 
-	if Args.cbSystemSecret == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbSystemSecret is a required paramter")
+	if args.SystemSecret == "" {
+		if args.silentMode {
+			fatalConfig("-cbSystemSecret is a required paramter")
 		}
 
 		value, err := readInput("Enter the system secret of the IoT Enterprise system the devices will be migrated to: ")
 		if err != nil {
-			log.Fatalln("Error reading system secret: ", err)
+			fatalConfig("Error reading system secret: ", err)
 		}
-		Args.cbSystemSecret = value
+		args.SystemSecret = value
 	}
 
-	if Args.cbDevEmail == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbDevEmail is a required paramter")
+	if args.DevEmail == "" {
+		if args.silentMode {
+			fatalConfig("-cbDevEmail is a required paramter")
 		}
 
 		value, err := readInput("Enter the developer email address that will be used to authenticate with the target IoT Enterprise system: ")
 		if err != nil {
-			log.Fatalln("Error developer email address: ", err)
+			fatalConfig("Error developer email address: ", err)
 		}
-		Args.cbDevEmail = value
+		args.DevEmail = value
 	}
 
-	if Args.cbDevPwd == "" {
-		if Args.silentMode {
-			log.Fatalln("-cbDevPwd is a required paramter")
+	if args.DevPassword == "" {
+		if args.silentMode {
+			fatalConfig("-cbDevPwd is a required paramter")
 		}
 
 		value, err := readPassword("Enter the developer password that will be used to authenticate with the target IoT Enterprise system: ")
 		if err != nil {
-			log.Fatalln("Error developer password: ", err)
+			fatalConfig("Error developer password: ", err)
 		}
-		Args.cbDevPwd = value
+		args.DevPassword = value
 	}
 
-	if Args.columnsCsvFile == "" {
-		if Args.silentMode {
-			return
-		}
-		value, err := readInput("Enter the path to a CSV file containing column mappings (Press enter to skip!): ")
-		if err != nil {
-			log.Fatalln("Error reading column map CSV file path: ", err)
-		}
-		Args.columnsCsvFile = value
-	}
+}
 
-	if Args.deviceType == "" {
-		if Args.silentMode {
-			return
+func validateSourcePath(args *cliArgs) {
+	if args.SourcePath == "" {
+		if args.silentMode {
+			fatalConfig("-sourcePath is a required parameter for source type", args.SourceType)
 		}
-		value, err := readInput("Enter the device type to assign to each migrated device (Press enter to skip!): ")
+		value, err := readInput("Enter the path of the " + args.SourceType + " to migrate devices from: ")
 		if err != nil {
-			log.Fatalln("Error reading device type: ", err)
-		}
-		Args.deviceType = value
-	}
-}
-
-func migrateDevices(deviceCount int) {
-	fmt.Println(string(colorCyan), "\n\n================= Starting Device Migration =================\n\nRunning Version: ", cbIotEnterpriseMigrationVersion, "\n\n", string(colorReset))
-	fmt.Println(string(colorCyan), "\nPreparing Device Migration\n", string(colorReset))
-
-	// Fetch devices from the given registry
-	errorLogs := migrateDevicesFromCbIotCore(deviceCount)
-	if len(errorLogs) > 0 {
-		fmt.Println("Invoking generateFailedDevicesCSV")
-		if err := generateFailedDevicesCSV(errorLogs); err != nil {
-			log.Fatalln(err)
+			fatalConfig("Error reading source path: ", err)
 		}
+		args.SourcePath = value
 	}
-
-	fmt.Println(string(colorGreen), "\n\n\u2713 Done!", string(colorReset))
 }
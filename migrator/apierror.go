@@ -0,0 +1,132 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// APIError is an error response from the IoT Enterprise API. Targets return it
+// for every failed call so callers can classify failures with IsConflict,
+// IsNotFound, IsRateLimited and IsAuth instead of matching messages.
+type APIError struct {
+	// StatusCode is the HTTP status of the response. Errors of the Go-SDK
+	// only carry the code of the error body, which is used as the status.
+	StatusCode int
+
+	// Code is the error code of a structured ClearBlade error body, or zero
+	// when the body did not carry one.
+	Code int
+
+	// Message is the error message of the response body.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// status returns the HTTP status that best describes the error. IoT Enterprise
+// sometimes answers with a generic status and puts the actual one in the
+// structured error body.
+func (e *APIError) status() int {
+	if e.Code >= 400 && e.Code < 600 {
+		return e.Code
+	}
+	return e.StatusCode
+}
+
+// sdkErrorCode and sdkErrorMessage match the code and the message of a
+// structured ClearBlade error body in the text of a Go-SDK error. The Go-SDK
+// does not keep the HTTP status of failed calls, but formats the decoded body
+// of the response into the error, like
+// map[error:map[code:409 message:Device with name 'x' already exists status:409]].
+var (
+	sdkErrorCode    = regexp.MustCompile(`\bcode:(\d{3})\b`)
+	sdkErrorMessage = regexp.MustCompile(`\bmessage:(.*?)(?: [a-z_]+:[^\]]*)?\]`)
+)
+
+// newSDKError returns an APIError for a Go-SDK error that carries a
+// structured ClearBlade error body, and any other error as is.
+func newSDKError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	match := sdkErrorCode.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+
+	code, _ := strconv.Atoi(match[1])
+	apiErr := &APIError{StatusCode: code, Code: code, Message: err.Error()}
+	if match := sdkErrorMessage.FindStringSubmatch(err.Error()); match != nil && match[1] != "" {
+		apiErr.Message = match[1]
+	}
+	return apiErr
+}
+
+func hasStatus(err error, statuses ...int) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	for _, status := range statuses {
+		if apiErr.status() == status {
+			return true
+		}
+	}
+	return false
+}
+
+// errorReason names the class of err for logging, or returns an empty string
+// when err is not of a known class.
+func errorReason(err error) string {
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		return "panic"
+	case errors.Is(err, ErrUnexpectedResponse):
+		return "unexpected_response"
+	case errors.Is(err, ErrUnsupportedCredential):
+		return "unsupported_credential"
+	case errors.Is(err, ErrMalformedCredential):
+		return "malformed_credential"
+	case errors.Is(err, ErrExpiredCredential):
+		return "expired_credential"
+	case IsConflict(err):
+		return "conflict"
+	case IsNotFound(err):
+		return "not_found"
+	case IsRateLimited(err):
+		return "rate_limited"
+	case IsAuth(err):
+		return "auth"
+	}
+	return ""
+}
+
+// IsConflict reports whether err is caused by a resource that already exists,
+// such as a device, a role or a role assignment.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// IsNotFound reports whether err is caused by a resource that does not exist.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsRateLimited reports whether err is caused by too many requests.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+// IsAuth reports whether err is caused by missing or rejected credentials,
+// including errors marked ErrAuth.
+func IsAuth(err error) bool {
+	return errors.Is(err, ErrAuth) || hasStatus(err, http.StatusUnauthorized, http.StatusForbidden)
+}
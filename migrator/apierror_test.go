@@ -0,0 +1,62 @@
+package migrator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewSDKError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{
+			name:        "mapped response",
+			err:         errors.New("map[error:map[code:409 message:Device with name 'device-1' already exists in system status:409]]"),
+			wantStatus:  409,
+			wantMessage: "Device with name 'device-1' already exists in system",
+		},
+		{
+			name:        "role create",
+			err:         errors.New("Error updating a role to have a collection: map[error:map[code:400 message:A role's name must be unique status:400]]"),
+			wantStatus:  400,
+			wantMessage: duplicateRoleMessage,
+		},
+		{
+			name:        "platform error body",
+			err:         errors.New("Error deleting role: map[error:map[category:Auth code:401 id:abc level:error message:Invalid token stack:none]]"),
+			wantStatus:  401,
+			wantMessage: "Invalid token",
+		},
+		{
+			name: "no error body",
+			err:  errors.New("Error Making Request: connection refused"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newSDKError(tt.err)
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				if tt.wantStatus != 0 {
+					t.Fatalf("newSDKError(%q) = %v, want an APIError", tt.err, err)
+				}
+				if err != tt.err {
+					t.Errorf("newSDKError(%q) = %v, want the error as is", tt.err, err)
+				}
+				return
+			}
+			if apiErr.status() != tt.wantStatus || apiErr.Message != tt.wantMessage {
+				t.Errorf("newSDKError(%q) = status %d message %q, want status %d message %q", tt.err, apiErr.status(), apiErr.Message, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+
+	if isDuplicateRole(newSDKError(errors.New("Error updating a role to have a collection: map[error:map[code:400 message:Invalid role name status:400]]"))) {
+		t.Error("a bad request other than a duplicate name is reported as a duplicate role")
+	}
+}
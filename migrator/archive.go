@@ -0,0 +1,184 @@
+package migrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// archiveFormatVersion is bumped whenever the layout of an archive changes in
+// a way older readers cannot handle.
+const archiveFormatVersion = 1
+
+const (
+	archiveManifestFile = "manifest.json"
+	archiveDevicesFile  = "devices.ndjson"
+)
+
+// ArchiveManifest describes the contents of an export archive. It is written
+// to manifest.json next to the devices file.
+type ArchiveManifest struct {
+	FormatVersion   int                       `json:"formatVersion"`
+	ToolVersion     string                    `json:"toolVersion"`
+	CreatedAt       string                    `json:"createdAt"`
+	Project         string                    `json:"project"`
+	Region          string                    `json:"region"`
+	Registry        string                    `json:"registry"`
+	RegistryDetails *cbiotcore.DeviceRegistry `json:"registryDetails,omitempty"`
+	DeviceCount     int                       `json:"deviceCount"`
+	FailedDevices   int                       `json:"failedDevices"`
+	DevicesFile     string                    `json:"devicesFile"`
+	DevicesSha256   string                    `json:"devicesSha256"`
+	Includes        ArchiveIncludes           `json:"includes"`
+}
+
+// ArchiveIncludes records which optional per-device data was exported.
+type ArchiveIncludes struct {
+	ConfigVersions bool `json:"configVersions"`
+	States         bool `json:"states"`
+	Bindings       bool `json:"bindings"`
+}
+
+// ArchiveDevice is a single line of the devices file.
+type ArchiveDevice struct {
+	Device         *cbiotcore.Device         `json:"device"`
+	ConfigVersions []*cbiotcore.DeviceConfig `json:"configVersions,omitempty"`
+	States         []*cbiotcore.DeviceState  `json:"states,omitempty"`
+	BoundDevices   []string                  `json:"boundDevices,omitempty"`
+}
+
+// archiveWriter streams devices to the devices file of an archive and writes
+// the manifest once all devices have been written.
+type archiveWriter struct {
+	dir     string
+	file    *os.File
+	hash    hash.Hash
+	encoder *json.Encoder
+	count   int
+}
+
+func newArchiveWriter(dir string) (*archiveWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, archiveManifestFile)); err == nil {
+		return nil, fmt.Errorf("%s already contains an archive", dir)
+	}
+
+	f, err := os.Create(filepath.Join(dir, archiveDevicesFile))
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	return &archiveWriter{
+		dir:     dir,
+		file:    f,
+		hash:    h,
+		encoder: json.NewEncoder(io.MultiWriter(f, h)),
+	}, nil
+}
+
+func (a *archiveWriter) WriteDevice(device *ArchiveDevice) error {
+	if err := a.encoder.Encode(device); err != nil {
+		return err
+	}
+	a.count++
+	return nil
+}
+
+// Close finishes the devices file and writes the manifest. The device count,
+// devices file name and checksum are filled in from what was written.
+func (a *archiveWriter) Close(manifest *ArchiveManifest) error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+
+	manifest.FormatVersion = archiveFormatVersion
+	manifest.DeviceCount = a.count
+	manifest.DevicesFile = archiveDevicesFile
+	manifest.DevicesSha256 = hex.EncodeToString(a.hash.Sum(nil))
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(a.dir, archiveManifestFile), content, 0644)
+}
+
+// ArchiveReader reads an archive created by Export.
+type ArchiveReader struct {
+	dir      string
+	Manifest *ArchiveManifest
+}
+
+// OpenArchive reads the manifest of the archive in dir and checks that the
+// devices file matches the checksum recorded at export time.
+func OpenArchive(dir string) (*ArchiveReader, error) {
+	content, err := os.ReadFile(filepath.Join(dir, archiveManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > archiveFormatVersion {
+		return nil, fmt.Errorf("unsupported archive format version %d (this version of the tool reads up to version %d)", manifest.FormatVersion, archiveFormatVersion)
+	}
+
+	f, err := os.Open(filepath.Join(dir, manifest.DevicesFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	if checksum := hex.EncodeToString(h.Sum(nil)); checksum != manifest.DevicesSha256 {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", manifest.DevicesFile, manifest.DevicesSha256, checksum)
+	}
+
+	return &ArchiveReader{dir: dir, Manifest: &manifest}, nil
+}
+
+// ReadDevices calls fn for every device in the archive, in the order they were
+// exported. Iteration stops at the first error returned by fn.
+func (a *ArchiveReader) ReadDevices(fn func(*ArchiveDevice) error) error {
+	f, err := os.Open(filepath.Join(a.dir, a.Manifest.DevicesFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var device ArchiveDevice
+		if err := decoder.Decode(&device); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if device.Device == nil {
+			return fmt.Errorf("archive entry without a device")
+		}
+
+		if err := fn(&device); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package migrator
+
+import (
+	"fmt"
+
+	cb "github.com/clearblade/Go-SDK"
+)
+
+func (m *Migrator) authenticateCbEnterprise() (*cb.DevClient, error) {
+	devClient := cb.NewDevClientWithAddrs(m.opts.EnterpriseUrl, m.opts.EnterpriseMsgUrl, m.opts.DevEmail, m.opts.DevPassword)
+	_, err := devClient.Authenticate()
+
+	if err != nil {
+		return nil, markError(ErrAuth, fmt.Errorf("unable to authenticate ClearBlade developer account: %w", err))
+	}
+
+	m.log.Info("Authenticated with ClearBlade IoT Enterprise", "url", m.opts.EnterpriseUrl, "systemKey", m.opts.SystemKey, "devEmail", m.opts.DevEmail)
+	fmt.Fprintln(m.out, string(colorGreen), "\n\u2713 ClearBlade Developer Account Authenticated!", string(colorReset))
+
+	return devClient, nil
+}
@@ -0,0 +1,217 @@
+package migrator
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// caCertificateFormat is the only format of IoT Core registry certificates.
+const caCertificateFormat = "X509_CERTIFICATE_PEM"
+
+// RegistryCredentialSource is implemented by device sources that know the CA
+// certificates of their registry, which IoT Core uses to verify the
+// certificates of X.509 devices.
+type RegistryCredentialSource interface {
+	// RegistryCredentials returns the CA certificates of the registry.
+	RegistryCredentials() ([]*cbiotcore.RegistryCredential, error)
+}
+
+// CACertificateTarget is implemented by targets that keep CA certificates
+// trusted for X.509 device authentication.
+type CACertificateTarget interface {
+	// GetCACertificates returns the PEM encoded CA certificates of the target.
+	GetCACertificates() ([]string, error)
+	AddCACertificate(certificate string) error
+}
+
+// Outcomes of a CA certificate migration.
+const (
+	CACertificateCreated = "created"
+	CACertificateExists  = "exists"
+	CACertificateSkipped = "skipped"
+	CACertificateFailed  = "failed"
+)
+
+// CACertificateResult is the outcome of migrating a registry CA certificate.
+type CACertificateResult struct {
+	// Index is the position of the certificate in the registry credentials.
+	Index int
+
+	// Subject and Fingerprint, the hex SHA-256 of the certificate, are empty
+	// when the certificate cannot be parsed.
+	Subject     string
+	Fingerprint string
+	ExpiresAt   time.Time
+
+	// Status is one of the CACertificate outcome constants. Err tells why a
+	// certificate was skipped or failed.
+	Status string
+	Err    error
+}
+
+// parseCACertificate parses the certificate of a registry credential. The
+// returned error is marked ErrUnsupportedCredential or ErrMalformedCredential.
+func parseCACertificate(credential *cbiotcore.RegistryCredential) (*x509.Certificate, error) {
+	if credential == nil || credential.PublicKeyCertificate == nil {
+		return nil, markError(ErrMalformedCredential, errors.New("registry credential has no certificate"))
+	}
+	if format := credential.PublicKeyCertificate.Format; format != "" && format != caCertificateFormat {
+		return nil, markError(ErrUnsupportedCredential, fmt.Errorf("unsupported certificate format %q", format))
+	}
+	return parseCertificatePEM(credential.PublicKeyCertificate.Certificate)
+}
+
+// parseCertificatePEM parses a single PEM encoded certificate. The returned
+// error is marked ErrMalformedCredential.
+func parseCertificatePEM(certificate string) (*x509.Certificate, error) {
+	block, rest := pem.Decode([]byte(certificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, markError(ErrMalformedCredential, errors.New("certificate is not a PEM encoded CERTIFICATE block"))
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		return nil, markError(ErrMalformedCredential, errors.New("certificate holds more than one PEM block"))
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, markError(ErrMalformedCredential, fmt.Errorf("invalid certificate: %w", err))
+	}
+	return cert, nil
+}
+
+// certificateFingerprint returns the hex SHA-256 of a certificate, so
+// certificates are compared regardless of their PEM line breaks.
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrateCACertificates adds the CA certificates of the source registry to the
+// CA certificates of the target, so devices with X.509 credentials issued by
+// them keep authenticating. Certificates already in the target are left as
+// they are, and expired certificates follow Options.ExpiredCredentials. An
+// error is returned when the certificates cannot be read, or when the source
+// or the target does not support CA certificates.
+func (m *Migrator) MigrateCACertificates() ([]CACertificateResult, error) {
+	source, err := m.Source()
+	if err != nil {
+		return nil, err
+	}
+	target, err := m.Target()
+	if err != nil {
+		return nil, err
+	}
+
+	registrySource, ok := source.(RegistryCredentialSource)
+	if !ok {
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("source type %s has no registry CA certificates", m.opts.SourceType))
+	}
+	caTarget, ok := target.(CACertificateTarget)
+	if !ok {
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("target type %s does not support CA certificates", m.opts.TargetType))
+	}
+
+	credentials, err := registrySource.RegistryCredentials()
+	if err != nil {
+		return nil, markError(ErrSource, fmt.Errorf("error fetching registry CA certificates: %w", err))
+	}
+
+	existing, err := caTarget.GetCACertificates()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching CA certificates of the target: %w", err)
+	}
+	fingerprints := make(map[string]bool, len(existing))
+	for _, certificate := range existing {
+		if cert, err := parseCertificatePEM(certificate); err == nil {
+			fingerprints[certificateFingerprint(cert)] = true
+		}
+	}
+
+	m.log.Info("Migrating CA certificates", "certificates", len(credentials), "existing", len(existing))
+	now := time.Now()
+	results := make([]CACertificateResult, 0, len(credentials))
+	for i, credential := range credentials {
+		result := m.migrateCACertificate(caTarget, i, credential, fingerprints, now)
+		m.log.Debug("Migrated CA certificate", "certificate", i, "subject", result.Subject, "fingerprint", result.Fingerprint, "status", result.Status)
+		if result.Status == CACertificateFailed {
+			m.log.Error("CA certificate failed", "certificate", i, "subject", result.Subject, "reason", errorReason(result.Err), "error", result.Err)
+		}
+		results = append(results, result)
+	}
+
+	m.printCACertificateResults(results)
+	return results, nil
+}
+
+func (m *Migrator) migrateCACertificate(target CACertificateTarget, index int, credential *cbiotcore.RegistryCredential, fingerprints map[string]bool, now time.Time) CACertificateResult {
+	result := CACertificateResult{Index: index}
+
+	cert, err := parseCACertificate(credential)
+	if err != nil {
+		result.Status = CACertificateFailed
+		result.Err = fmt.Errorf("CA certificate %d: %w", index, err)
+		return result
+	}
+	result.Subject = cert.Subject.String()
+	result.Fingerprint = certificateFingerprint(cert)
+	result.ExpiresAt = cert.NotAfter
+
+	if fingerprints[result.Fingerprint] {
+		result.Status = CACertificateExists
+		return result
+	}
+
+	if cert.NotAfter.Before(now) {
+		expiredErr := markError(ErrExpiredCredential, fmt.Errorf("CA certificate %d expired on %s", index, cert.NotAfter.UTC().Format(time.RFC3339)))
+		switch m.opts.ExpiredCredentials {
+		case ExpiredCredentialsSkip:
+			m.log.Warn("Skipped expired CA certificate", "certificate", index, "subject", result.Subject, "expiresAt", cert.NotAfter)
+			result.Status = CACertificateSkipped
+			result.Err = expiredErr
+			return result
+		case ExpiredCredentialsFail:
+			result.Status = CACertificateFailed
+			result.Err = expiredErr
+			return result
+		default:
+			m.log.Warn("Migrating expired CA certificate", "certificate", index, "subject", result.Subject, "expiresAt", cert.NotAfter)
+		}
+	}
+
+	if err := target.AddCACertificate(credential.PublicKeyCertificate.Certificate); err != nil {
+		result.Status = CACertificateFailed
+		result.Err = fmt.Errorf("CA certificate %d: %w", index, err)
+		return result
+	}
+	fingerprints[result.Fingerprint] = true
+	result.Status = CACertificateCreated
+	return result
+}
+
+func (m *Migrator) printCACertificateResults(results []CACertificateResult) {
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+		if result.Err != nil {
+			color := colorYellow
+			if result.Status == CACertificateFailed {
+				color = colorRed
+			}
+			fmt.Fprintf(m.out, "%s%s: %v\n%s", string(color), result.Status, result.Err, string(colorReset))
+		}
+	}
+
+	color := colorGreen
+	if counts[CACertificateFailed] > 0 {
+		color = colorRed
+	}
+	fmt.Fprintln(m.out, string(color), "\n\u2713", len(results), "CA certificates:", counts[CACertificateCreated], "created,", counts[CACertificateExists], "already present,", counts[CACertificateSkipped], "skipped,", counts[CACertificateFailed], "failed", string(colorReset))
+}
@@ -0,0 +1,83 @@
+package migrator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+type caTargetStub struct {
+	certificates []string
+}
+
+func (s *caTargetStub) GetCACertificates() ([]string, error) {
+	return s.certificates, nil
+}
+
+func (s *caTargetStub) AddCACertificate(certificate string) error {
+	s.certificates = append(s.certificates, certificate)
+	return nil
+}
+
+func TestMigrateCACertificate(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := certificatePEM(t, ecKey)
+	credential := func(format, certificate string) *cbiotcore.RegistryCredential {
+		return &cbiotcore.RegistryCredential{PublicKeyCertificate: &cbiotcore.PublicKeyCertificate{Format: format, Certificate: certificate}}
+	}
+
+	m := &Migrator{out: io.Discard, log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	target := &caTargetStub{}
+	fingerprints := make(map[string]bool)
+	now := time.Now()
+
+	if result := m.migrateCACertificate(target, 0, credential(caCertificateFormat, cert), fingerprints, now); result.Status != CACertificateCreated {
+		t.Fatalf("status = %s, want %s: %v", result.Status, CACertificateCreated, result.Err)
+	}
+
+	// The same certificate with other line endings has the same fingerprint
+	crlf := strings.ReplaceAll(cert, "\n", "\r\n")
+	if result := m.migrateCACertificate(target, 1, credential("", crlf), fingerprints, now); result.Status != CACertificateExists {
+		t.Fatalf("status = %s, want %s: %v", result.Status, CACertificateExists, result.Err)
+	}
+	if len(target.certificates) != 1 {
+		t.Fatalf("target has %d certificates, want 1", len(target.certificates))
+	}
+
+	tests := []struct {
+		name       string
+		credential *cbiotcore.RegistryCredential
+		wantErr    error
+	}{
+		{name: "no certificate", credential: &cbiotcore.RegistryCredential{}, wantErr: ErrMalformedCredential},
+		{name: "unknown format", credential: credential("RSA_PEM", cert), wantErr: ErrUnsupportedCredential},
+		{name: "not pem", credential: credential(caCertificateFormat, "certificate"), wantErr: ErrMalformedCredential},
+	}
+	for _, tt := range tests {
+		result := m.migrateCACertificate(target, 2, tt.credential, fingerprints, now)
+		if result.Status != CACertificateFailed || !errors.Is(result.Err, tt.wantErr) {
+			t.Errorf("%s: status %s with error %v, want %s with %v", tt.name, result.Status, result.Err, CACertificateFailed, tt.wantErr)
+		}
+	}
+
+	// The certificate expires an hour from now
+	later := now.Add(2 * time.Hour)
+	for policy, want := range map[string]string{ExpiredCredentialsSkip: CACertificateSkipped, ExpiredCredentialsFail: CACertificateFailed} {
+		m.opts.ExpiredCredentials = policy
+		result := m.migrateCACertificate(&caTargetStub{}, 0, credential(caCertificateFormat, cert), make(map[string]bool), later)
+		if result.Status != want || !errors.Is(result.Err, ErrExpiredCredential) {
+			t.Errorf("%s policy: status %s with error %v, want %s", policy, result.Status, result.Err, want)
+		}
+	}
+}
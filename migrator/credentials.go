@@ -0,0 +1,328 @@
+package migrator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	cb "github.com/clearblade/Go-SDK"
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// Public key formats of IoT Core device credentials.
+const (
+	KeyFormatUnspecified = "UNSPECIFIED_PUBLIC_KEY_FORMAT"
+	KeyFormatRSA         = "RSA_PEM"
+	KeyFormatRSAX509     = "RSA_X509_PEM"
+	KeyFormatES256       = "ES256_PEM"
+	KeyFormatES256X509   = "ES256_X509_PEM"
+)
+
+// noExpiration is the expiration time IoT Core reports for credentials that do
+// not expire.
+const noExpiration = "1970-01-01T00:00:00Z"
+
+// deviceKey is a device credential validated for upload to the target.
+type deviceKey struct {
+	Key    string
+	Format cb.KeyFormat
+
+	// ExpirationTime is empty when the credential does not expire.
+	ExpirationTime string
+
+	// Certificate is the parsed certificate of X.509 credentials, and nil for
+	// bare public keys.
+	Certificate *x509.Certificate
+
+	// ExpiresAt is the earliest of the expiration time and the certificate
+	// expiry, or zero when the credential never expires.
+	ExpiresAt time.Time
+}
+
+// expired reports whether the credential expired before now.
+func (k *deviceKey) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && k.ExpiresAt.Before(now)
+}
+
+// keyFormats maps the IoT Core key formats to the IoT Enterprise ones, and
+// tells whether the key is wrapped in a certificate.
+var keyFormats = map[string]struct {
+	format      cb.KeyFormat
+	certificate bool
+}{
+	KeyFormatRSA:       {cb.RS256, false},
+	KeyFormatRSAX509:   {cb.RS256_X509, true},
+	KeyFormatES256:     {cb.ES256, false},
+	KeyFormatES256X509: {cb.ES256_X509, true},
+}
+
+// parseCredential validates a device credential locally, so a key the target
+// would reject or could not use is reported before anything is uploaded. The
+// returned error is marked ErrUnsupportedCredential or ErrMalformedCredential.
+func parseCredential(cred *cbiotcore.DeviceCredential) (*deviceKey, error) {
+	if cred == nil || cred.PublicKey == nil {
+		return nil, markError(ErrMalformedCredential, fmt.Errorf("credential has no public key"))
+	}
+
+	keyFormat, ok := keyFormats[cred.PublicKey.Format]
+	if !ok {
+		if cred.PublicKey.Format == "" || cred.PublicKey.Format == KeyFormatUnspecified {
+			return nil, markError(ErrUnsupportedCredential, fmt.Errorf("public key format is not specified"))
+		}
+		return nil, markError(ErrUnsupportedCredential, fmt.Errorf("unsupported public key format %q", cred.PublicKey.Format))
+	}
+
+	key := &deviceKey{Key: cred.PublicKey.Key, Format: keyFormat.format}
+
+	if cred.ExpirationTime != "" && cred.ExpirationTime != noExpiration {
+		expiresAt, err := time.Parse(time.RFC3339, cred.ExpirationTime)
+		if err != nil {
+			return nil, markError(ErrMalformedCredential, fmt.Errorf("invalid expiration time %q: %w", cred.ExpirationTime, err))
+		}
+		key.ExpirationTime = cred.ExpirationTime
+		key.ExpiresAt = expiresAt
+	}
+
+	block, rest := pem.Decode([]byte(cred.PublicKey.Key))
+	if block == nil {
+		return nil, markError(ErrMalformedCredential, fmt.Errorf("%s key is not PEM encoded", cred.PublicKey.Format))
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		return nil, markError(ErrMalformedCredential, fmt.Errorf("%s key holds more than one PEM block", cred.PublicKey.Format))
+	}
+
+	var publicKey interface{}
+	if keyFormat.certificate {
+		if block.Type != "CERTIFICATE" {
+			return nil, markError(ErrMalformedCredential, fmt.Errorf("%s key holds a %s PEM block, want CERTIFICATE", cred.PublicKey.Format, block.Type))
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, markError(ErrMalformedCredential, fmt.Errorf("invalid %s certificate: %w", cred.PublicKey.Format, err))
+		}
+		key.Certificate = cert
+		publicKey = cert.PublicKey
+		if key.ExpiresAt.IsZero() || cert.NotAfter.Before(key.ExpiresAt) {
+			key.ExpiresAt = cert.NotAfter
+		}
+	} else {
+		if block.Type != "PUBLIC KEY" {
+			return nil, markError(ErrMalformedCredential, fmt.Errorf("%s key holds a %s PEM block, want PUBLIC KEY", cred.PublicKey.Format, block.Type))
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, markError(ErrMalformedCredential, fmt.Errorf("invalid %s public key: %w", cred.PublicKey.Format, err))
+		}
+		publicKey = parsed
+	}
+
+	if err := checkPublicKeyAlgorithm(cred.PublicKey.Format, publicKey); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// checkPublicKeyAlgorithm checks that the key matches the algorithm of its
+// format: RSA for RS256, and ECDSA on the P-256 curve for ES256.
+func checkPublicKeyAlgorithm(format string, publicKey interface{}) error {
+	switch format {
+	case KeyFormatRSA, KeyFormatRSAX509:
+		if _, ok := publicKey.(*rsa.PublicKey); !ok {
+			return markError(ErrMalformedCredential, fmt.Errorf("%s key is a %T, want an RSA key", format, publicKey))
+		}
+	case KeyFormatES256, KeyFormatES256X509:
+		ecKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return markError(ErrMalformedCredential, fmt.Errorf("%s key is a %T, want an ECDSA key", format, publicKey))
+		}
+		if ecKey.Curve != elliptic.P256() {
+			return markError(ErrMalformedCredential, fmt.Errorf("%s key uses the %s curve, want P-256", format, ecKey.Curve.Params().Name))
+		}
+	}
+	return nil
+}
+
+// Expired credential policies, selecting what a migration does with a
+// credential whose expiration time or certificate expiry has passed.
+const (
+	// ExpiredCredentialsMigrate uploads expired credentials like any other.
+	ExpiredCredentialsMigrate = "migrate"
+
+	// ExpiredCredentialsSkip leaves expired credentials out without failing
+	// their device.
+	ExpiredCredentialsSkip = "skip"
+
+	// ExpiredCredentialsFail leaves expired credentials out and fails their
+	// device.
+	ExpiredCredentialsFail = "fail"
+)
+
+// Credential statuses reported by CheckCredentials.
+const (
+	CredentialValid       = "valid"
+	CredentialExpired     = "expired"
+	CredentialUnsupported = "unsupported"
+	CredentialMalformed   = "malformed"
+)
+
+// Actions a migration takes for a credential.
+const (
+	// CredentialActionMigrate uploads the credential.
+	CredentialActionMigrate = "migrate"
+
+	// CredentialActionSkip leaves the credential out.
+	CredentialActionSkip = "skip"
+
+	// CredentialActionFail leaves the credential out and fails its device.
+	CredentialActionFail = "fail"
+)
+
+// CredentialCheck is the outcome of the local validation of a device
+// credential.
+type CredentialCheck struct {
+	DeviceId string
+
+	// Index is the position of the credential in the credentials of the
+	// device, as used in failure messages.
+	Index  int
+	Format string
+
+	// Status is one of the Credential status constants and Action one of the
+	// CredentialAction constants.
+	Status string
+	Action string
+
+	// ExpiresAt is the earliest of the expiration time and the certificate
+	// expiry, or zero when the credential never expires or cannot be parsed.
+	ExpiresAt time.Time
+
+	// Err tells why the credential is not migrated, and is nil when it is.
+	Err error
+
+	key *deviceKey
+}
+
+// checkCredential validates a device credential and picks the action of the
+// migration for it, applying the expired credentials policy.
+func (m *Migrator) checkCredential(deviceId string, index int, cred *cbiotcore.DeviceCredential, now time.Time) CredentialCheck {
+	check := CredentialCheck{DeviceId: deviceId, Index: index, Status: CredentialValid, Action: CredentialActionMigrate}
+	if cred != nil && cred.PublicKey != nil {
+		check.Format = cred.PublicKey.Format
+	}
+
+	key, err := parseCredential(cred)
+	if err != nil {
+		check.Status = CredentialMalformed
+		if errors.Is(err, ErrUnsupportedCredential) {
+			check.Status = CredentialUnsupported
+		}
+		check.Action = CredentialActionFail
+		check.Err = fmt.Errorf("credential %d: %w", index, err)
+		return check
+	}
+	check.key = key
+	check.ExpiresAt = key.ExpiresAt
+
+	if !key.expired(now) {
+		return check
+	}
+
+	check.Status = CredentialExpired
+	switch m.opts.ExpiredCredentials {
+	case ExpiredCredentialsSkip:
+		check.Action = CredentialActionSkip
+	case ExpiredCredentialsFail:
+		check.Action = CredentialActionFail
+	default:
+		return check
+	}
+	check.Err = markError(ErrExpiredCredential, fmt.Errorf("credential %d expired on %s", index, key.ExpiresAt.UTC().Format(time.RFC3339)))
+	return check
+}
+
+// checkDeviceCredentials checks every credential of a device.
+func (m *Migrator) checkDeviceCredentials(deviceId string, credentials []*cbiotcore.DeviceCredential) []CredentialCheck {
+	now := time.Now()
+	checks := make([]CredentialCheck, 0, len(credentials))
+	for i, cred := range credentials {
+		checks = append(checks, m.checkCredential(deviceId, i, cred, now))
+	}
+	return checks
+}
+
+// CredentialReport is the result of CheckCredentials.
+type CredentialReport struct {
+	// Devices is the number of devices checked, including the devices without
+	// credentials.
+	Devices     int
+	Credentials []CredentialCheck
+
+	// MissingIds lists the devices of the device list missing from the source.
+	MissingIds []string
+
+	// KeylessDevices lists the devices without any credential, which can
+	// only connect with an active key once migrated.
+	KeylessDevices []string
+}
+
+// Count returns the number of credentials with the given status.
+func (r *CredentialReport) Count(status string) int {
+	count := 0
+	for _, check := range r.Credentials {
+		if check.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// FailedDevices returns the IDs of the devices a migration would fail because
+// of their credentials, in the order they were checked.
+func (r *CredentialReport) FailedDevices() []string {
+	var deviceIds []string
+	for _, check := range r.Credentials {
+		if check.Action == CredentialActionFail && !slices.Contains(deviceIds, check.DeviceId) {
+			deviceIds = append(deviceIds, check.DeviceId)
+		}
+	}
+	return deviceIds
+}
+
+// CheckCredentials validates the credentials of the devices selected by the
+// options, the same way a migration does before uploading them. Only the
+// source is read.
+func (m *Migrator) CheckCredentials() (*CredentialReport, error) {
+	source, err := m.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	devices, _, missingIds, err := m.collectDevices(source)
+	if err != nil {
+		return nil, markError(ErrSource, fmt.Errorf("error fetching devices: %w", err))
+	}
+
+	report := &CredentialReport{Devices: len(devices), MissingIds: missingIds}
+	for _, device := range devices {
+		credentials, err := source.Credentials(device)
+		if err != nil {
+			return nil, markError(ErrSource, fmt.Errorf("error fetching credentials of device %s: %w", device.Id, err))
+		}
+
+		if len(credentials) == 0 {
+			report.KeylessDevices = append(report.KeylessDevices, device.Id)
+		}
+		for _, check := range m.checkDeviceCredentials(device.Id, credentials) {
+			m.log.Debug("Checked device credential", "device", device.Id, "credential", check.Index, "format", check.Format, "status", check.Status, "action", check.Action)
+			report.Credentials = append(report.Credentials, check)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,154 @@
+package migrator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	cb "github.com/clearblade/Go-SDK"
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+func publicKeyPEM(t *testing.T, signer crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func certificatePEM(t *testing.T, signer crypto.Signer) string {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "device"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestParseCredential(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		format     string
+		key        string
+		expiration string
+		want       cb.KeyFormat
+		wantErr    error
+	}{
+		{name: "rsa key", format: KeyFormatRSA, key: publicKeyPEM(t, rsaKey), expiration: noExpiration, want: cb.RS256},
+		{name: "rsa certificate", format: KeyFormatRSAX509, key: certificatePEM(t, rsaKey), want: cb.RS256_X509},
+		{name: "es256 key", format: KeyFormatES256, key: publicKeyPEM(t, ecKey), expiration: "2030-01-01T00:00:00Z", want: cb.ES256},
+		{name: "es256 certificate", format: KeyFormatES256X509, key: certificatePEM(t, ecKey), want: cb.ES256_X509},
+		{name: "unspecified format", format: KeyFormatUnspecified, key: publicKeyPEM(t, rsaKey), wantErr: ErrUnsupportedCredential},
+		{name: "empty format", key: publicKeyPEM(t, rsaKey), wantErr: ErrUnsupportedCredential},
+		{name: "unknown format", format: "ED25519_PEM", key: publicKeyPEM(t, rsaKey), wantErr: ErrUnsupportedCredential},
+		{name: "not pem", format: KeyFormatRSA, key: "rsa-key", wantErr: ErrMalformedCredential},
+		{name: "two pem blocks", format: KeyFormatRSA, key: publicKeyPEM(t, rsaKey) + publicKeyPEM(t, rsaKey), wantErr: ErrMalformedCredential},
+		{name: "certificate for key format", format: KeyFormatRSA, key: certificatePEM(t, rsaKey), wantErr: ErrMalformedCredential},
+		{name: "key for certificate format", format: KeyFormatES256X509, key: publicKeyPEM(t, ecKey), wantErr: ErrMalformedCredential},
+		{name: "corrupt key", format: KeyFormatRSA, key: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("corrupt")})), wantErr: ErrMalformedCredential},
+		{name: "rsa key for es256", format: KeyFormatES256, key: publicKeyPEM(t, rsaKey), wantErr: ErrMalformedCredential},
+		{name: "ec key for rsa", format: KeyFormatRSAX509, key: certificatePEM(t, ecKey), wantErr: ErrMalformedCredential},
+		{name: "es256 key on p384", format: KeyFormatES256, key: publicKeyPEM(t, p384Key), wantErr: ErrMalformedCredential},
+		{name: "invalid expiration", format: KeyFormatRSA, key: publicKeyPEM(t, rsaKey), expiration: "tomorrow", wantErr: ErrMalformedCredential},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseCredential(&cbiotcore.DeviceCredential{
+				PublicKey:      &cbiotcore.PublicKeyCredential{Format: tt.format, Key: tt.key},
+				ExpirationTime: tt.expiration,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Format != tt.want {
+				t.Errorf("format = %v, want %v", key.Format, tt.want)
+			}
+			if tt.expiration == noExpiration && key.ExpirationTime != "" {
+				t.Errorf("expiration = %q, want none", key.ExpirationTime)
+			}
+		})
+	}
+
+	if _, err := parseCredential(&cbiotcore.DeviceCredential{}); !errors.Is(err, ErrMalformedCredential) {
+		t.Errorf("credential without public key: err = %v, want ErrMalformedCredential", err)
+	}
+}
+
+func TestCheckCredentialExpiry(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &cbiotcore.DeviceCredential{
+		PublicKey:      &cbiotcore.PublicKeyCredential{Format: KeyFormatES256X509, Key: certificatePEM(t, ecKey)},
+		ExpirationTime: "2099-01-01T00:00:00Z",
+	}
+
+	// The certificate expires an hour from now, before its expiration time
+	now := time.Now()
+	tests := []struct {
+		policy     string
+		now        time.Time
+		wantStatus string
+		wantAction string
+	}{
+		{policy: ExpiredCredentialsFail, now: now, wantStatus: CredentialValid, wantAction: CredentialActionMigrate},
+		{policy: ExpiredCredentialsMigrate, now: now.Add(2 * time.Hour), wantStatus: CredentialExpired, wantAction: CredentialActionMigrate},
+		{policy: ExpiredCredentialsSkip, now: now.Add(2 * time.Hour), wantStatus: CredentialExpired, wantAction: CredentialActionSkip},
+		{policy: ExpiredCredentialsFail, now: now.Add(2 * time.Hour), wantStatus: CredentialExpired, wantAction: CredentialActionFail},
+	}
+
+	for _, tt := range tests {
+		m := &Migrator{opts: Options{ExpiredCredentials: tt.policy}}
+		check := m.checkCredential("device", 0, cert, tt.now)
+		if check.Status != tt.wantStatus || check.Action != tt.wantAction {
+			t.Errorf("%s policy: status %s and action %s, want %s and %s", tt.policy, check.Status, check.Action, tt.wantStatus, tt.wantAction)
+		}
+		if check.ExpiresAt.After(now.Add(time.Hour)) {
+			t.Errorf("%s policy: expires at %s, want the certificate expiry", tt.policy, check.ExpiresAt)
+		}
+		if (check.Action == CredentialActionMigrate) != (check.Err == nil) {
+			t.Errorf("%s policy: action %s with error %v", tt.policy, check.Action, check.Err)
+		}
+		if check.Err != nil && !errors.Is(check.Err, ErrExpiredCredential) {
+			t.Errorf("%s policy: err = %v, want ErrExpiredCredential", tt.policy, check.Err)
+		}
+	}
+}
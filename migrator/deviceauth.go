@@ -0,0 +1,147 @@
+package migrator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// DeviceAuthRule sets the authentication of the devices it matches. A device
+// matches a rule when it matches every condition the rule sets, and only the
+// first matching rule of a rules file applies. Unset outcomes keep the values
+// given by the options.
+type DeviceAuthRule struct {
+	// IdPattern matches device IDs with the syntax of path.Match, such as
+	// "sensor-*".
+	IdPattern string `json:"idPattern,omitempty"`
+
+	// Metadata matches devices whose metadata holds every given value.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// HasCredentials matches devices with credentials when true and devices
+	// without any when false.
+	HasCredentials *bool `json:"hasCredentials,omitempty"`
+
+	AllowKeyAuth         *bool `json:"allowKeyAuth,omitempty"`
+	AllowCertificateAuth *bool `json:"allowCertificateAuth,omitempty"`
+	GenerateActiveKey    *bool `json:"generateActiveKey,omitempty"`
+}
+
+// matches reports whether the rule applies to a device with the given number
+// of credentials.
+func (r *DeviceAuthRule) matches(device *cbiotcore.Device, credentials int) bool {
+	if r.IdPattern != "" {
+		if ok, _ := path.Match(r.IdPattern, device.Id); !ok {
+			return false
+		}
+	}
+	for key, value := range r.Metadata {
+		if actual, ok := device.Metadata[key]; !ok || actual != value {
+			return false
+		}
+	}
+	if r.HasCredentials != nil && *r.HasCredentials != (credentials > 0) {
+		return false
+	}
+	return true
+}
+
+// readDeviceAuthRules reads a JSON array of device auth rules.
+func readDeviceAuthRules(rulesFile string) ([]DeviceAuthRule, error) {
+	absPath, err := getAbsPath(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve device auth rules filepath: %w", err)
+	}
+
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read device auth rules: %w", err)
+	}
+
+	var rules []DeviceAuthRule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse device auth rules %s: %w", rulesFile, err)
+	}
+
+	for i, rule := range rules {
+		if _, err := path.Match(rule.IdPattern, ""); err != nil {
+			return nil, fmt.Errorf("device auth rule %d: invalid idPattern %q: %w", i, rule.IdPattern, err)
+		}
+		if rule.AllowKeyAuth == nil && rule.AllowCertificateAuth == nil && rule.GenerateActiveKey == nil {
+			return nil, fmt.Errorf("device auth rule %d: sets neither allowKeyAuth, allowCertificateAuth nor generateActiveKey", i)
+		}
+	}
+	return rules, nil
+}
+
+// deviceAuthRules returns the rules given by Options.DeviceAuthRulesFile,
+// reading the file on first use.
+func (m *Migrator) deviceAuthRules() ([]DeviceAuthRule, error) {
+	if m.authRulesLoaded || m.opts.DeviceAuthRulesFile == "" {
+		return m.authRules, nil
+	}
+
+	rules, err := readDeviceAuthRules(m.opts.DeviceAuthRulesFile)
+	if err != nil {
+		return nil, markError(ErrInvalidOptions, err)
+	}
+	m.authRules = rules
+	m.authRulesLoaded = true
+	return rules, nil
+}
+
+// deviceAuth is how a migrated device authenticates.
+type deviceAuth struct {
+	allowKeyAuth         bool
+	allowCertificateAuth bool
+
+	// generateActiveKey gives the device a random active key when it is
+	// created, and allows key auth for it. Devices that already exist keep
+	// their key and allowKeyAuth.
+	generateActiveKey bool
+}
+
+// deviceAuth returns the authentication of a device with the given number of
+// credentials: the values of the options, replaced by the first matching
+// rule. Columns of the device list still take precedence over both.
+func (m *Migrator) deviceAuth(device *cbiotcore.Device, credentials int) deviceAuth {
+	auth := deviceAuth{
+		allowKeyAuth:         m.opts.AllowKeyAuth,
+		allowCertificateAuth: !m.opts.DisableCertificateAuth,
+		generateActiveKey:    m.opts.GenerateActiveKeys && credentials == 0,
+	}
+
+	for _, rule := range m.authRules {
+		if !rule.matches(device, credentials) {
+			continue
+		}
+		if rule.AllowKeyAuth != nil {
+			auth.allowKeyAuth = *rule.AllowKeyAuth
+		}
+		if rule.AllowCertificateAuth != nil {
+			auth.allowCertificateAuth = *rule.AllowCertificateAuth
+		}
+		if rule.GenerateActiveKey != nil {
+			auth.generateActiveKey = *rule.GenerateActiveKey
+		}
+		break
+	}
+	return auth
+}
+
+// activeKeyBytes is the number of random bytes of a generated active key.
+const activeKeyBytes = 24
+
+// generateActiveKey returns a random hex encoded device active key.
+func generateActiveKey() (string, error) {
+	key := make([]byte, activeKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("unable to generate device active key: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
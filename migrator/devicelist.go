@@ -0,0 +1,273 @@
+package migrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StdinDeviceList is the DevicesFile value that reads the device list from stdin.
+const StdinDeviceList = "-"
+
+// DeviceListEntry is a single device selected for migration. Overrides holds
+// device column values that replace the transformed values for this device.
+type DeviceListEntry struct {
+	Id        string
+	Overrides map[string]interface{}
+}
+
+// readDeviceList reads the devices to migrate from a CSV, JSON array or NDJSON
+// file, or from stdin when path is "-". The format is picked from the file
+// extension and falls back to sniffing the content.
+func readDeviceList(path string) ([]*DeviceListEntry, error) {
+	var content []byte
+	var err error
+
+	if path == StdinDeviceList {
+		content, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read device list from stdin: %w", err)
+		}
+	} else {
+		absPath, err := getAbsPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve devices filepath: %w", err)
+		}
+
+		content, err = os.ReadFile(absPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read device list: %w", err)
+		}
+	}
+
+	var entries []*DeviceListEntry
+	switch deviceListFormat(path, content) {
+	case "json":
+		entries, err = parseJSONDeviceList(content)
+	case "ndjson":
+		entries, err = parseNDJSONDeviceList(content)
+	default:
+		entries, err = parseCSVDeviceList(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse device list %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+func deviceListFormat(path string, content []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".csv":
+		return "csv"
+	}
+
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 {
+		return "csv"
+	}
+
+	// A leading quote is left to CSV, whose first field may be quoted. NDJSON
+	// lines holding only a quoted ID read the same as a CSV list of IDs.
+	switch trimmed[0] {
+	case '[':
+		return "json"
+	case '{':
+		return "ndjson"
+	}
+	return "csv"
+}
+
+// parseCSVDeviceList reads the device ID from the first column. When the first
+// row is a header naming an ID column (see isDeviceIdColumn), every other
+// named column is read as a per-device override.
+func parseCSVDeviceList(content []byte) ([]*DeviceListEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 || !hasDeviceListHeader(records[0]) {
+		entries := make([]*DeviceListEntry, 0, len(records))
+		for lineNum, line := range records {
+			id := strings.TrimSpace(line[0])
+			if id == "" {
+				return nil, fmt.Errorf("line %d: missing device id", lineNum+1)
+			}
+			entries = append(entries, &DeviceListEntry{Id: id})
+		}
+		return entries, nil
+	}
+
+	header := records[0]
+	idColumn := -1
+	for i, column := range header {
+		if isDeviceIdColumn(column) {
+			idColumn = i
+			break
+		}
+	}
+
+	entries := make([]*DeviceListEntry, 0, len(records)-1)
+	for lineNum, line := range records[1:] {
+		if idColumn >= len(line) || strings.TrimSpace(line[idColumn]) == "" {
+			return nil, fmt.Errorf("line %d: missing device id", lineNum+2)
+		}
+
+		entry := &DeviceListEntry{Id: strings.TrimSpace(line[idColumn])}
+		for i, value := range line {
+			if i == idColumn || i >= len(header) || value == "" {
+				continue
+			}
+
+			column := strings.TrimSpace(header[i])
+			if column == "" {
+				continue
+			}
+
+			override, err := parseCSVOverride(column, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum+2, err)
+			}
+
+			if entry.Overrides == nil {
+				entry.Overrides = make(map[string]interface{})
+			}
+			entry.Overrides[column] = override
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func hasDeviceListHeader(line []string) bool {
+	for _, column := range line {
+		if isDeviceIdColumn(column) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDeviceIdColumn(column string) bool {
+	switch strings.ToLower(strings.TrimSpace(column)) {
+	case "id", "device_id", "deviceid":
+		return true
+	}
+	return false
+}
+
+// parseCSVOverride converts a CSV cell to the type expected by the devices
+// collection. Only the boolean device columns need converting; everything else
+// is passed through as a string.
+func parseCSVOverride(column, value string) (interface{}, error) {
+	switch column {
+	case "enabled", "allow_key_auth", "allow_certificate_auth":
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for column %s: %w", value, column, err)
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+func parseJSONDeviceList(content []byte) ([]*DeviceListEntry, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(content, &items); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*DeviceListEntry, 0, len(items))
+	for i, item := range items {
+		entry, err := parseDeviceListItem(item)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseNDJSONDeviceList(content []byte) ([]*DeviceListEntry, error) {
+	entries := make([]*DeviceListEntry, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		entry, err := parseDeviceListItem(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// parseDeviceListItem accepts either a bare device ID string or an object with
+// an "id" field. Any other fields of the object become column overrides.
+func parseDeviceListItem(item json.RawMessage) (*DeviceListEntry, error) {
+	var id string
+	if err := json.Unmarshal(item, &id); err == nil {
+		if id == "" {
+			return nil, fmt.Errorf("empty device id")
+		}
+		return &DeviceListEntry{Id: id}, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(item, &fields); err != nil {
+		return nil, fmt.Errorf("expected a device id or an object: %w", err)
+	}
+
+	id, _ = fields["id"].(string)
+	if id == "" {
+		return nil, fmt.Errorf("missing \"id\" field")
+	}
+	delete(fields, "id")
+
+	entry := &DeviceListEntry{Id: id}
+	if len(fields) > 0 {
+		entry.Overrides = fields
+	}
+	return entry, nil
+}
+
+func getDeviceListIds(entries []*DeviceListEntry) []string {
+	deviceIds := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		deviceIds = append(deviceIds, entry.Id)
+	}
+	return deviceIds
+}
+
+func getDeviceListOverrides(entries []*DeviceListEntry) map[string]map[string]interface{} {
+	overrides := make(map[string]map[string]interface{})
+	for _, entry := range entries {
+		if entry.Overrides != nil {
+			overrides[entry.Id] = entry.Overrides
+		}
+	}
+	return overrides
+}
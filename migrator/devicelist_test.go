@@ -0,0 +1,85 @@
+package migrator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDeviceListStdin(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		wantIds       []string
+		wantOverrides map[string]interface{}
+	}{
+		{
+			name:    "quoted csv",
+			content: "\"device-1\",\"sensor, outdoor\"\n\"device-2\",\n",
+			wantIds: []string{"device-1", "device-2"},
+		},
+		{
+			name:          "quoted csv with header",
+			content:       "\"id\",\"type\"\n\"device-1\",\"sensor, outdoor\"\n",
+			wantIds:       []string{"device-1"},
+			wantOverrides: map[string]interface{}{"type": "sensor, outdoor"},
+		},
+		{
+			name:          "ndjson",
+			content:       "{\"id\": \"device-1\", \"type\": \"gateway\"}\n{\"id\": \"device-2\"}\n",
+			wantIds:       []string{"device-1", "device-2"},
+			wantOverrides: map[string]interface{}{"type": "gateway"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin := filepath.Join(t.TempDir(), "stdin")
+			if err := os.WriteFile(stdin, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			original := os.Stdin
+			os.Stdin = f
+			defer func() { os.Stdin = original }()
+
+			entries, err := readDeviceList(StdinDeviceList)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.wantIds) {
+				t.Fatalf("got %d entries, want %v", len(entries), tt.wantIds)
+			}
+			for i, entry := range entries {
+				if entry.Id != tt.wantIds[i] {
+					t.Errorf("entry %d ID = %q, want %q", i, entry.Id, tt.wantIds[i])
+				}
+			}
+			for column, want := range tt.wantOverrides {
+				if got := entries[0].Overrides[column]; got != want {
+					t.Errorf("%s override = %v, want %v", column, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCSVDeviceListWithoutHeader(t *testing.T) {
+	entries, err := parseCSVDeviceList([]byte(" device-1 \ndevice-2\t,sensor\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Id != "device-1" || entries[1].Id != "device-2" {
+		t.Fatalf("entries = %+v, want device-1 and device-2 trimmed", entries)
+	}
+
+	_, err = parseCSVDeviceList([]byte("device-1\n  ,sensor\n"))
+	if err == nil || err.Error() != "line 2: missing device id" {
+		t.Errorf("error = %v, want line 2: missing device id", err)
+	}
+}
@@ -0,0 +1,303 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// deviceMigration migrates a single device and records every failed step in
+// result.
+type deviceMigration func(result *DeviceResult, source DeviceSource, device *cbiotcore.Device, overrides map[string]interface{})
+
+// migrateDevicesToClearBlade runs migrate for every device received on
+// devicesC until the channel is closed, and collects exactly one DeviceResult
+// per device. total is only used to size the progress bar, which is resized
+// when the source sends another number of devices.
+// overrides holds per-device column values keyed by device ID and may be nil.
+func (m *Migrator) migrateDevicesToClearBlade(source DeviceSource, devicesC <-chan *cbiotcore.Device, total int, overrides map[string]map[string]interface{}, migrate deviceMigration) *Result {
+	bar := getProgressBar(m.out, total, "Migrating Devices...")
+	errorLogs := make([]ErrorLog, 0)
+	deviceResults := make([]DeviceResult, 0, total)
+	successfulCreates := 0
+
+	wp := NewWorkerPool(TotalWorkers, TaskQueueSize)
+	wp.Run(context.Background())
+	defer m.closeWorkerPool(wp)
+
+	// Results are collected while devices are queued, so workers never wait
+	// on a full result channel while the queue waits on the workers
+	resultC := make(chan *DeviceResult)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for res := range resultC {
+			deviceResults = append(deviceResults, *res)
+			if res.Failed() {
+				for _, errorLog := range res.Errors {
+					m.log.Error("Device step failed", "device", errorLog.DeviceId, "step", errorLog.Context, "reason", errorReason(errorLog.Error), "error", errorLog.Error)
+				}
+				errorLogs = append(errorLogs, res.Errors...)
+			} else {
+				m.log.Info("Device migrated", "device", res.DeviceId)
+				successfulCreates += 1
+			}
+		}
+	}()
+
+	var pending sync.WaitGroup
+	migrated := 0
+	for device := range devicesC {
+		device := device
+		// The progress bar only fails when the terminal cannot be written to
+		_ = bar.Add(1)
+		pending.Add(1)
+		err := wp.AddTask(func(ctx context.Context) error {
+			defer pending.Done()
+			result := &DeviceResult{DeviceId: device.Id}
+			m.runDeviceMigration(migrate, result, source, device, overrides[device.Id])
+			resultC <- result
+			return nil
+		})
+		if err != nil {
+			result := &DeviceResult{DeviceId: device.Id}
+			_ = result.fail("Error when queuing device", err)
+			resultC <- result
+			pending.Done()
+		}
+		migrated++
+	}
+
+	if migrated != total {
+		bar.ChangeMax(migrated)
+	}
+
+	pending.Wait()
+	close(resultC)
+	<-collected
+
+	if successfulCreates == migrated {
+		fmt.Fprintln(m.out, string(colorGreen), "\n\n\u2713 Migrated", successfulCreates, "/", migrated, "devices!", string(colorReset))
+	} else {
+		fmt.Fprintln(m.out, string(colorRed), "\n\n\u2715 Failed to migrate all devices. Migrated", successfulCreates, "/", migrated, "devices!", string(colorReset))
+	}
+
+	return &Result{
+		Devices:       migrated,
+		Migrated:      successfulCreates,
+		Failed:        errorLogs,
+		DeviceResults: deviceResults,
+	}
+}
+
+// runDeviceMigration runs migrate for a single device. A panic in any step is
+// recorded as a failure of that device, so the other devices still migrate.
+func (m *Migrator) runDeviceMigration(migrate deviceMigration, result *DeviceResult, source DeviceSource, device *cbiotcore.Device, overrides map[string]interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := newPanicError(r)
+			m.log.Debug("Device migration panicked", "device", device.Id, "stack", string(err.Stack))
+			_ = result.fail("Unexpected error when migrating device", err)
+		}
+	}()
+
+	migrate(result, source, device, overrides)
+}
+
+// closeWorkerPool stops the workers once every queued task ran, and logs the
+// failed tasks and the pool metrics.
+func (m *Migrator) closeWorkerPool(wp WorkerPool) {
+	wp.Close()
+	wp.Wait()
+
+	for _, err := range wp.Errors() {
+		m.log.Error("Worker task failed", "error", err)
+	}
+
+	stats := wp.Stats()
+	m.log.Debug("Worker pool finished", "completed", stats.Completed, "failed", stats.Failed)
+	for worker, workerStats := range stats.Workers {
+		m.log.Debug("Worker finished", "worker", worker, "completed", workerStats.Completed, "failed", workerStats.Failed, "busy", workerStats.Busy, "tasksPerSecond", workerStats.Throughput())
+	}
+}
+
+func (m *Migrator) migrateDevice(result *DeviceResult, source DeviceSource, device *cbiotcore.Device, overrides map[string]interface{}) {
+	// The credentials pick how the device authenticates, so they are needed
+	// before the device is written
+	credentials, err := source.Credentials(device)
+	if err != nil {
+		result.fail("Error when fetching device credentials", err)
+		return
+	}
+
+	auth := m.deviceAuth(device, len(credentials))
+	if len(credentials) == 0 {
+		m.recordKeyless(result, auth)
+	}
+
+	//* Create or update the device
+	if err := m.createOrUpdateDevice(result, device, auth, overrides); err != nil {
+		return
+	}
+
+	// Device Create/Update Successful. The role does not depend on the keys,
+	// so it is set up even when the device has none or a key failed
+	if m.opts.UpdatePublicKeys && len(credentials) > 0 {
+		_ = m.createDeviceCredentials(result, device, credentials)
+	}
+
+	//Should roles and permissions be created?
+	if m.opts.CreateDeviceRole {
+		_ = m.createDeviceRole(result, device, overrides)
+	}
+}
+
+// recordKeyless marks a device without credentials in its result, and warns
+// when nothing lets it authenticate once migrated.
+func (m *Migrator) recordKeyless(result *DeviceResult, auth deviceAuth) {
+	result.Keyless = true
+	if auth.allowKeyAuth || auth.generateActiveKey {
+		m.log.Info("Device has no credentials", "device", result.DeviceId, "activeKey", auth.generateActiveKey)
+		return
+	}
+	m.log.Warn("Device has no credentials and key auth is not allowed", "device", result.DeviceId)
+}
+
+// migrateDeviceKeys replaces the public keys of a device that was already
+// migrated.
+func (m *Migrator) migrateDeviceKeys(result *DeviceResult, source DeviceSource, device *cbiotcore.Device, overrides map[string]interface{}) {
+	credentials, err := source.Credentials(device)
+	if err != nil {
+		result.fail("Error when fetching device credentials", err)
+		return
+	}
+
+	if len(credentials) == 0 {
+		result.Keyless = true
+		return
+	}
+	_ = m.createDeviceCredentials(result, device, credentials)
+}
+
+func (m *Migrator) createOrUpdateDevice(result *DeviceResult, device *cbiotcore.Device, auth deviceAuth, overrides map[string]interface{}) error {
+	m.log.Debug("Creating device", "device", device.Id, "step", "create")
+	activeKey, err := m.createDevice(device, auth, overrides)
+	if err == nil {
+		if activeKey != "" {
+			m.log.Info("Generated device active key", "device", device.Id, "step", "create")
+			result.ActiveKey = activeKey
+		}
+		return nil
+	}
+
+	// The device exists when the create conflicts, so patch it. Other create
+	// errors are only reported when the patch fails too, as the device may
+	// exist all the same.
+	m.log.Debug("Updating device", "device", device.Id, "step", "update")
+	if _, updateErr := m.updateDevice(device, auth, overrides); updateErr != nil {
+		if !IsConflict(err) {
+			result.fail("Error when Creating Device", err)
+		}
+		return result.fail("Error when Patching Device", updateErr)
+	}
+	return nil
+}
+
+func (m *Migrator) updateDevice(device *cbiotcore.Device, auth deviceAuth, overrides map[string]interface{}) (map[string]interface{}, error) {
+	cbDevice := transform(device, m.opts.DeviceType, auth, m.columns, overrides)
+
+	// No key is generated for an existing device, so key auth is neither
+	// forced on nor taken away from a device that may have one
+	if _, ok := overrides["allow_key_auth"]; auth.generateActiveKey && !auth.allowKeyAuth && !ok {
+		delete(cbDevice, "allow_key_auth")
+	}
+	return m.target.UpdateDevice(device.Id, cbDevice)
+}
+
+// createDevice creates the device and returns the active key generated for
+// it, if any. Keys are only generated on create, so the key of a device that
+// was already migrated is never replaced, and an active_key column of the
+// device list takes precedence.
+func (m *Migrator) createDevice(device *cbiotcore.Device, auth deviceAuth, overrides map[string]interface{}) (string, error) {
+	if auth.generateActiveKey {
+		auth.allowKeyAuth = true
+	}
+	cbDevice := transform(device, m.opts.DeviceType, auth, m.columns, overrides)
+
+	activeKey := ""
+	if _, ok := overrides["active_key"]; auth.generateActiveKey && !ok {
+		var err error
+		if activeKey, err = generateActiveKey(); err != nil {
+			return "", err
+		}
+		cbDevice["active_key"] = activeKey
+	}
+
+	if _, err := m.target.CreateDevice(device.Id, cbDevice); err != nil {
+		return "", err
+	}
+	return activeKey, nil
+}
+
+// createDeviceCredentials replaces the public keys of a device with its valid
+// credentials. Unsupported and malformed credentials are reported and skipped;
+// the existing keys of the device are removed even when none is valid.
+func (m *Migrator) createDeviceCredentials(result *DeviceResult, device *cbiotcore.Device, credentials []*cbiotcore.DeviceCredential) error {
+	keys, parseErr := m.parseDeviceCredentials(result, credentials)
+
+	// The existing device keys are deleted even when no credential is left to
+	// upload, so keys of an earlier run do not outlive credentials that are
+	// now skipped or invalid
+	if len(keys) == 0 {
+		m.log.Warn("Removing device public keys, as none of its credentials are migrated", "device", device.Id, "step", "keys", "credentials", len(credentials))
+	} else {
+		m.log.Debug("Replacing device public keys", "device", device.Id, "step", "keys", "keys", len(keys))
+	}
+	if err := m.target.DeleteDevicePublicKeys(device.Id); err != nil {
+		return result.fail("Error when deleting device credentials", err)
+	}
+
+	//Create the device creds
+	for _, key := range keys {
+		if _, err := m.target.AddDevicePublicKey(device.Id, key.Key, key.ExpirationTime, key.Format); err != nil {
+			return result.fail("Error when creating device credential", err)
+		}
+	}
+	return parseErr
+}
+
+// parseDeviceCredentials returns the credentials of a device to upload. Every
+// credential left out is logged, and the ones that fail the device are
+// recorded as failed steps. The returned error is the last one recorded.
+func (m *Migrator) parseDeviceCredentials(result *DeviceResult, credentials []*cbiotcore.DeviceCredential) ([]*deviceKey, error) {
+	keys := make([]*deviceKey, 0, len(credentials))
+	var lastErr error
+	for _, check := range m.checkDeviceCredentials(result.DeviceId, credentials) {
+		switch check.Action {
+		case CredentialActionMigrate:
+			if check.Status == CredentialExpired {
+				m.log.Warn("Migrating expired device credential", "device", result.DeviceId, "step", "keys", "credential", check.Index, "expiresAt", check.ExpiresAt)
+			}
+			keys = append(keys, check.key)
+		case CredentialActionSkip:
+			m.log.Warn("Skipped device credential", "device", result.DeviceId, "step", "keys", "credential", check.Index, "reason", errorReason(check.Err), "error", check.Err)
+		case CredentialActionFail:
+			lastErr = result.fail(credentialContext(check.Status), check.Err)
+		}
+	}
+	return keys, lastErr
+}
+
+// credentialContext returns the failed step reported for a credential with the
+// given status.
+func credentialContext(status string) string {
+	switch status {
+	case CredentialUnsupported:
+		return "Unsupported device credential"
+	case CredentialExpired:
+		return "Expired device credential"
+	}
+	return "Malformed device credential"
+}
@@ -0,0 +1,71 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+func TestMigrateDevicesRecoversPanics(t *testing.T) {
+	m := &Migrator{out: io.Discard, log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	devices := []string{"device-1", "device-2", "device-3"}
+	devicesC := make(chan *cbiotcore.Device, len(devices))
+	for _, id := range devices {
+		devicesC <- &cbiotcore.Device{Id: id}
+	}
+	close(devicesC)
+
+	result := m.migrateDevicesToClearBlade(nil, devicesC, len(devices), nil, func(result *DeviceResult, source DeviceSource, device *cbiotcore.Device, overrides map[string]interface{}) {
+		if device.Id == "device-2" {
+			var role interface{}
+			_ = role.(map[string]interface{})
+		}
+	})
+
+	if result.Devices != 3 || result.Migrated != 2 || len(result.DeviceResults) != 3 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].DeviceId != "device-2" {
+		t.Fatalf("failed = %+v, want only device-2", result.Failed)
+	}
+
+	var panicErr *PanicError
+	if err := result.Failed[0].Error; !errors.As(err, &panicErr) || errorReason(err) != "panic" {
+		t.Fatalf("panic reported as %v, want a *PanicError", err)
+	}
+}
+
+func TestMigrateDevicesBeyondTotal(t *testing.T) {
+	m := &Migrator{out: io.Discard, log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	// Far more devices than the total, the workers and the task queue hold
+	count := TaskQueueSize + 3*TotalWorkers
+	devicesC := make(chan *cbiotcore.Device)
+	go func() {
+		defer close(devicesC)
+		for i := 0; i < count; i++ {
+			devicesC <- &cbiotcore.Device{Id: fmt.Sprintf("device-%d", i)}
+		}
+	}()
+
+	done := make(chan *Result)
+	go func() {
+		done <- m.migrateDevicesToClearBlade(nil, devicesC, 1, nil, func(result *DeviceResult, source DeviceSource, device *cbiotcore.Device, overrides map[string]interface{}) {
+		})
+	}()
+
+	select {
+	case result := <-done:
+		if result.Devices != count || result.Migrated != count || len(result.DeviceResults) != count {
+			t.Fatalf("unexpected result: %d devices, %d migrated, %d results, want %d", result.Devices, result.Migrated, len(result.DeviceResults), count)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("migration of more devices than the total did not finish")
+	}
+}
@@ -0,0 +1,55 @@
+package migrator
+
+import "errors"
+
+// Errors returned by the Migrator are marked with one of these kinds, so
+// callers can tell them apart with errors.Is. The message of a marked error is
+// unchanged.
+var (
+	// ErrInvalidOptions marks missing or invalid options, including source,
+	// target and service account files that cannot be read.
+	ErrInvalidOptions = errors.New("invalid options")
+
+	// ErrAuth marks a failure to authenticate with the target.
+	ErrAuth = errors.New("authentication failed")
+
+	// ErrSource marks a failure to read devices from the source.
+	ErrSource = errors.New("device source failure")
+
+	// ErrUnexpectedResponse marks a response of the source or the target that
+	// does not have the expected shape.
+	ErrUnexpectedResponse = errors.New("unexpected response")
+
+	// ErrUnsupportedCredential marks a device credential whose key format
+	// cannot be migrated.
+	ErrUnsupportedCredential = errors.New("unsupported credential")
+
+	// ErrMalformedCredential marks a device credential whose key or
+	// certificate cannot be parsed, or does not match its format.
+	ErrMalformedCredential = errors.New("malformed credential")
+
+	// ErrExpiredCredential marks a device credential left out of a migration
+	// because it expired.
+	ErrExpiredCredential = errors.New("expired credential")
+)
+
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// markError marks err with kind. A nil err is returned as is.
+func markError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
@@ -0,0 +1,187 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// ExportOptions selects the optional data written by Export.
+type ExportOptions struct {
+	SkipConfigs  bool
+	SkipStates   bool
+	SkipBindings bool
+}
+
+// Export writes every device of the ClearBlade IoT Core registry, along with
+// the optional data selected by opts, to an archive in outDir. Devices whose
+// extra data cannot be fetched are still written and reported in the returned
+// logs.
+func (m *Migrator) Export(outDir string, opts ExportOptions) ([]ErrorLog, error) {
+	if err := m.connectIotCore(); err != nil {
+		return nil, err
+	}
+	source := m.newIotCoreSource()
+
+	outDir, err := getAbsPath(outDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve output directory: %w", err)
+	}
+
+	writer, err := newArchiveWriter(outDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create archive: %w", err)
+	}
+
+	manifest := &ArchiveManifest{
+		ToolVersion: Version,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		Project:     m.project,
+		Region:      m.opts.RegistryRegion,
+		Registry:    m.opts.RegistryName,
+		Includes: ArchiveIncludes{
+			ConfigVersions: !opts.SkipConfigs,
+			States:         !opts.SkipStates,
+			Bindings:       !opts.SkipBindings,
+		},
+	}
+
+	fmt.Fprintln(m.out, string(colorCyan), "\n\n================= Starting Registry Export =================\n\nRunning Version: ", Version, "\n\n", string(colorReset))
+
+	registry, err := cbiotcore.NewProjectsLocationsRegistriesService(m.service).Get(m.registryPath).Do()
+	if err != nil {
+		fmt.Fprintf(m.out, "%sWarning: unable to fetch registry details - %s\n%s", string(colorYellow), err.Error(), string(colorReset))
+	} else {
+		manifest.RegistryDetails = registry
+	}
+
+	m.log.Info("Starting Registry Export", "registry", m.registryPath, "out", outDir, "version", Version)
+	devices, err := source.fetchAllDevices()
+	if err != nil {
+		return nil, markError(ErrSource, fmt.Errorf("error fetching all devices: %w", err))
+	}
+	fmt.Fprintln(m.out, string(colorGreen), "\n\u2713 Fetched", len(devices), "devices", string(colorReset))
+
+	archiveDevices := make([]*ArchiveDevice, len(devices))
+	// Each device can report up to one error per exported data type, and one
+	// more when its export panics
+	resultC := make(chan ErrorLog, 4*len(devices))
+	bar := getProgressBar(m.out, len(devices), "Exporting Devices...")
+
+	wp := NewWorkerPool(TotalWorkers, TaskQueueSize)
+	wp.Run(context.Background())
+
+	for i := 0; i < len(devices); i++ {
+		idx := i
+		err := wp.AddTask(func(ctx context.Context) error {
+			archiveDevices[idx] = m.exportDeviceSafely(resultC, source, devices[idx], opts)
+			_ = bar.Add(1)
+			return nil
+		})
+		if err != nil {
+			m.closeWorkerPool(wp)
+			return nil, err
+		}
+	}
+	m.closeWorkerPool(wp)
+	close(resultC)
+
+	errorLogs := make([]ErrorLog, 0)
+	failed := make(map[string]bool)
+	for res := range resultC {
+		m.log.Error("Device export step failed", "device", res.DeviceId, "step", res.Context, "error", res.Error)
+		errorLogs = append(errorLogs, res)
+		failed[res.DeviceId] = true
+	}
+
+	for _, device := range archiveDevices {
+		if err := writer.WriteDevice(device); err != nil {
+			return nil, fmt.Errorf("unable to write device to archive: %w", err)
+		}
+	}
+
+	manifest.FailedDevices = len(failed)
+	if err := writer.Close(manifest); err != nil {
+		return nil, fmt.Errorf("unable to write archive manifest: %w", err)
+	}
+
+	m.log.Info("Finished Registry Export", "devices", len(devices), "incomplete", len(failed))
+	if len(failed) == 0 {
+		fmt.Fprintln(m.out, string(colorGreen), "\n\n\u2713 Exported", len(devices), "devices to", outDir, string(colorReset))
+	} else {
+		fmt.Fprintln(m.out, string(colorYellow), "\n\nExported", len(devices), "devices to", outDir, "-", len(failed), "devices are incomplete", string(colorReset))
+	}
+
+	return errorLogs, nil
+}
+
+// exportDeviceSafely runs exportDevice and reports a panic as a failure of the
+// device, whose archive entry then only holds the device itself.
+func (m *Migrator) exportDeviceSafely(resultC chan ErrorLog, source *iotCoreSource, device *cbiotcore.Device, opts ExportOptions) (archiveDevice *ArchiveDevice) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := newPanicError(r)
+			m.log.Debug("Device export panicked", "device", device.Id, "stack", string(err.Stack))
+			resultC <- ErrorLog{
+				DeviceId: device.Id,
+				Context:  "Unexpected error when exporting device",
+				Error:    err,
+			}
+			archiveDevice = &ArchiveDevice{Device: device}
+		}
+	}()
+
+	return m.exportDevice(resultC, source, device, opts)
+}
+
+func (m *Migrator) exportDevice(resultC chan ErrorLog, source *iotCoreSource, device *cbiotcore.Device, opts ExportOptions) *ArchiveDevice {
+	archiveDevice := &ArchiveDevice{Device: device}
+	devicePath := m.devicePath(device.Id)
+
+	if !opts.SkipConfigs {
+		resp, err := cbiotcore.NewProjectsLocationsRegistriesDevicesConfigVersionsService(m.service).List(devicePath).Do()
+		if err != nil {
+			resultC <- ErrorLog{
+				DeviceId: device.Id,
+				Context:  "Error when fetching device config versions",
+				Error:    err,
+			}
+		} else {
+			archiveDevice.ConfigVersions = resp.DeviceConfigs
+		}
+	}
+
+	if !opts.SkipStates {
+		resp, err := cbiotcore.NewProjectsLocationsRegistriesDevicesStatesService(m.service).List(devicePath).Do()
+		if err != nil {
+			resultC <- ErrorLog{
+				DeviceId: device.Id,
+				Context:  "Error when fetching device states",
+				Error:    err,
+			}
+		} else {
+			archiveDevice.States = resp.DeviceStates
+		}
+	}
+
+	if !opts.SkipBindings && isGateway(device) {
+		boundDevices, err := source.fetchBoundDeviceIds(device.Id)
+		if err != nil {
+			resultC <- ErrorLog{
+				DeviceId: device.Id,
+				Context:  "Error when fetching gateway bindings",
+				Error:    err,
+			}
+		} else {
+			archiveDevice.BoundDevices = boundDevices
+		}
+	}
+
+	return archiveDevice
+}
+
+func isGateway(device *cbiotcore.Device) bool {
+	return device.GatewayConfig != nil && device.GatewayConfig.GatewayType == "GATEWAY"
+}
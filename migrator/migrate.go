@@ -0,0 +1,182 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Result summarizes a migration run.
+type Result struct {
+	// Devices is the number of devices the migration was attempted for and
+	// Migrated the number of devices migrated without error.
+	Devices  int
+	Migrated int
+
+	// Failed holds an entry for every failed step of a device migration.
+	Failed []ErrorLog
+
+	// DeviceResults holds the outcome of every device the migration was
+	// attempted for, in the order the devices finished.
+	DeviceResults []DeviceResult
+
+	// MissingIds lists the devices of the device list missing from the source.
+	MissingIds []string
+
+	// CACertificates holds the outcome of every registry CA certificate when
+	// Options.MigrateCACertificates is set.
+	CACertificates []CACertificateResult
+}
+
+// ActiveKeys returns the active keys generated for the created devices, keyed
+// by device ID.
+func (r *Result) ActiveKeys() map[string]string {
+	keys := make(map[string]string)
+	for _, deviceResult := range r.DeviceResults {
+		if deviceResult.ActiveKey != "" {
+			keys[deviceResult.DeviceId] = deviceResult.ActiveKey
+		}
+	}
+	return keys
+}
+
+// KeylessDevices returns the IDs of the devices the source has no credentials
+// for, in the order they finished.
+func (r *Result) KeylessDevices() []string {
+	var deviceIds []string
+	for _, deviceResult := range r.DeviceResults {
+		if deviceResult.Keyless {
+			deviceIds = append(deviceIds, deviceResult.DeviceId)
+		}
+	}
+	return deviceIds
+}
+
+// FailedCACertificates returns the number of registry CA certificates that
+// failed to migrate.
+func (r *Result) FailedCACertificates() int {
+	failed := 0
+	for _, certificate := range r.CACertificates {
+		if certificate.Status == CACertificateFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// DeviceResult is the outcome of migrating a single device. Errors holds every
+// failed step of the device and is empty when the device was migrated.
+type DeviceResult struct {
+	DeviceId string
+	Errors   []ErrorLog
+
+	// ActiveKey is the active key generated for the device when it was
+	// created, and is empty otherwise.
+	ActiveKey string
+
+	// Keyless reports that the source has no credentials for the device.
+	Keyless bool
+}
+
+// Failed reports whether any step of the device migration failed.
+func (r *DeviceResult) Failed() bool {
+	return len(r.Errors) > 0
+}
+
+// fail records a failed step and returns err, so steps can report and return
+// in one statement.
+func (r *DeviceResult) fail(context string, err error) error {
+	r.Errors = append(r.Errors, ErrorLog{DeviceId: r.DeviceId, Context: context, Error: err})
+	return err
+}
+
+// Migrate migrates the devices selected by the options from the source to the
+// target. Device failures are reported in the result; an error is only
+// returned when the migration could not run or the source failed part way, in
+// which case the result of the devices read until then is also returned.
+func (m *Migrator) Migrate() (*Result, error) {
+	// Certificates go first, so X.509 devices can authenticate as soon as
+	// they are migrated
+	var certificates []CACertificateResult
+	if m.opts.MigrateCACertificates {
+		var err error
+		if certificates, err = m.MigrateCACertificates(); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := m.run("Device Migration", m.migrateDevice)
+	if result != nil {
+		result.CACertificates = certificates
+	}
+	return result, err
+}
+
+// MigrateKeys replaces the public keys of the selected devices, which must
+// already exist in the target, with the credentials from the source.
+func (m *Migrator) MigrateKeys() (*Result, error) {
+	return m.run("Key Migration", m.migrateDeviceKeys)
+}
+
+// MigrateRoles creates the role of every selected device and assigns it to the
+// device, which must already exist in the target.
+func (m *Migrator) MigrateRoles() (*Result, error) {
+	return m.run("Role Migration", m.migrateDeviceRole)
+}
+
+func (m *Migrator) run(name string, migrate deviceMigration) (*Result, error) {
+	source, err := m.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := m.Target(); err != nil {
+		return nil, err
+	}
+
+	deviceCount, err := source.Count()
+	if err != nil {
+		return nil, markError(ErrSource, fmt.Errorf("error retrieving registry device count: %w", err))
+	}
+
+	m.log.Info("Starting "+name, "sourceDevices", deviceCount, "sourceType", m.opts.SourceType, "targetType", m.opts.TargetType, "version", Version)
+
+	if deviceCount == 0 {
+		fmt.Fprintln(m.out, string(colorRed), "\n\n\u2715 No devices in registry. Skipping migration.", string(colorReset))
+		return &Result{}, nil
+	}
+
+	fmt.Fprintln(m.out, string(colorCyan), "\n\n================= Starting "+name+" =================\n\nRunning Version: ", Version, "\n\n", string(colorReset))
+	fmt.Fprintln(m.out, string(colorCyan), "\nPreparing "+name+"\n", string(colorReset))
+
+	if _, err := m.columnMapping(); err != nil {
+		return nil, err
+	}
+	if _, err := m.deviceAuthRules(); err != nil {
+		return nil, err
+	}
+	roles, err := m.roleConfig()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := m.target.(RolePermissionTarget); roles.hasPlatformPermissions() && !ok {
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("target type %s cannot grant roles collection, service or devices permissions", m.opts.TargetType))
+	}
+	if roles.Devices != nil && !m.opts.AllowSharedDeviceAccess {
+		return nil, markError(ErrInvalidOptions, errors.New("the devices permissions of the role configuration give every device access to every device row; allow them with -allowSharedDeviceAccess"))
+	}
+	if err := m.checkSharedRoleConfig(roles); err != nil {
+		return nil, markError(ErrInvalidOptions, err)
+	}
+	if _, ok := m.target.(RoleConsolidationTarget); m.opts.ConsolidateRoles && !ok {
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("target type %s cannot consolidate roles", m.opts.TargetType))
+	}
+	if m.opts.ConsolidateRoles {
+		m.log.Warn("Consolidating roles deletes the per-device roles of the devices", "strategy", m.opts.RoleStrategy, "role", m.opts.SharedRoleName)
+	}
+
+	result, err := m.migrateDevicesFromSource(source, deviceCount, migrate)
+	if result != nil {
+		m.log.Info("Finished "+name, "devices", result.Devices, "migrated", result.Migrated, "errors", len(result.Failed), "missing", len(result.MissingIds))
+	}
+	return result, err
+}
@@ -0,0 +1,338 @@
+// Package migrator migrates devices from ClearBlade IoT Core, or from another
+// device source, to a ClearBlade IoT Enterprise system.
+//
+// A Migrator is created from Options and connects to its source and target
+// the first time they are needed:
+//
+//	m, err := migrator.New(migrator.Options{...})
+//	if err != nil {
+//		return err
+//	}
+//	defer m.Close()
+//
+//	result, err := m.Migrate()
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+const TotalWorkers = 10
+
+// TaskQueueSize is the number of devices queued for the workers at most.
+const TaskQueueSize = 10 * TotalWorkers
+
+// Options configures a Migrator. Paths may start with ~ for the home directory.
+type Options struct {
+	// ClearBlade IoT Core registry, used by the iotcore source and by Export
+	ServiceAccount string
+	RegistryName   string
+	RegistryRegion string
+
+	// ClearBlade IoT Enterprise system, used by the enterprise target
+	EnterpriseUrl    string
+	EnterpriseMsgUrl string
+	SystemKey        string
+	SystemSecret     string
+	DevEmail         string
+	DevPassword      string
+
+	// SourceType is one of the SourceType constants and defaults to
+	// SourceTypeIotCore. SourcePath is the archive directory or inventory file
+	// for the other source types.
+	SourceType string
+	SourcePath string
+
+	// TargetType is one of the TargetType constants and defaults to
+	// TargetTypeEnterprise. TargetPath is the JSON file of the file target.
+	TargetType string
+	TargetPath string
+
+	// Source and Target are used instead of SourceType and TargetType when set.
+	Source DeviceSource
+	Target DeviceTarget
+
+	// DevicesFile is a CSV, JSON or NDJSON list of the devices to migrate,
+	// or "-" for stdin. DeviceIds selects devices by ID instead. Every device
+	// of the source is migrated when both are empty.
+	DevicesFile   string
+	DeviceIds     []string
+	ColumnMapFile string
+	DeviceType    string
+
+	// PageSize defaults to 100 and FetchWorkers to 5.
+	PageSize     int
+	FetchWorkers int
+
+	UpdatePublicKeys bool
+	CreateDeviceRole bool
+
+	// RoleConfigFile is a JSON RoleConfig with the topic permissions of the
+	// device roles. Roles get the IoT Core topic layout when it is empty.
+	RoleConfigFile string
+
+	// RoleStrategy is one of the RoleStrategy constants and defaults to
+	// RoleStrategyDevice. SharedRoleName names the shared role, and prefixes
+	// the device type roles, and defaults to DefaultSharedRoleName.
+	// ConsolidateRoles takes the per-device roles of earlier migrations away
+	// from the devices assigned a shared role, and deletes them. Roles named
+	// after a device that grant more than its topics are left as they are.
+	RoleStrategy     string
+	SharedRoleName   string
+	ConsolidateRoles bool
+
+	// AllowSharedDeviceAccess lets shared and device type roles render the
+	// {device_id} and metadata tokens of their topics as wildcards, which
+	// gives every device the topics of every other device assigned the role.
+	// ConsolidateRoles requires it, as it deletes the per-device roles, and so
+	// do the devices permissions of the role configuration, which apply to
+	// every device row.
+	AllowSharedDeviceAccess bool
+
+	// AllowKeyAuth and DisableCertificateAuth set the allow_key_auth and
+	// allow_certificate_auth columns of every device, which otherwise only
+	// allow certificate auth. GenerateActiveKeys gives the devices without
+	// credentials a random active key, and allows them key auth, when they
+	// are created. DeviceAuthRulesFile is a JSON array of DeviceAuthRule
+	// setting these per device.
+	AllowKeyAuth           bool
+	DisableCertificateAuth bool
+	GenerateActiveKeys     bool
+	DeviceAuthRulesFile    string
+
+	// ExpiredCredentials is one of the ExpiredCredentials policies and
+	// defaults to ExpiredCredentialsMigrate.
+	ExpiredCredentials string
+
+	// MigrateCACertificates makes Migrate add the CA certificates of the
+	// source registry to the target before migrating the devices.
+	MigrateCACertificates bool
+
+	// Output receives progress and summary messages. Defaults to os.Stdout.
+	Output io.Writer
+
+	// Logger receives a structured record of every step, with device and
+	// step attributes. Nothing is logged when nil.
+	Logger *slog.Logger
+}
+
+// Migrator migrates devices from a DeviceSource to a DeviceTarget. A Migrator
+// is not safe for concurrent use.
+type Migrator struct {
+	opts Options
+	out  io.Writer
+	log  *slog.Logger
+
+	service      *cbiotcore.Service
+	regDetails   *cbiotcore.RegistryUserCredentials
+	project      string
+	registryPath string
+
+	source DeviceSource
+	target DeviceTarget
+
+	columns       []columnMapping
+	columnsLoaded bool
+
+	authRules       []DeviceAuthRule
+	authRulesLoaded bool
+
+	roles *RoleConfig
+
+	sharedRolesMu sync.Mutex
+	sharedRoles   map[string]*sharedRole
+}
+
+// New creates a Migrator. It does not connect to the source or the target, so
+// options required by them are only checked once they are first used.
+func New(opts Options) (*Migrator, error) {
+	if opts.SourceType == "" {
+		opts.SourceType = SourceTypeIotCore
+	}
+	if opts.TargetType == "" {
+		opts.TargetType = TargetTypeEnterprise
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = 100
+	}
+	if opts.FetchWorkers <= 0 {
+		opts.FetchWorkers = 5
+	}
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+	switch opts.ExpiredCredentials {
+	case "":
+		opts.ExpiredCredentials = ExpiredCredentialsMigrate
+	case ExpiredCredentialsMigrate, ExpiredCredentialsSkip, ExpiredCredentialsFail:
+	default:
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("unknown expired credentials policy %q. Supported policies are %s, %s and %s", opts.ExpiredCredentials, ExpiredCredentialsMigrate, ExpiredCredentialsSkip, ExpiredCredentialsFail))
+	}
+	switch opts.RoleStrategy {
+	case "":
+		opts.RoleStrategy = RoleStrategyDevice
+	case RoleStrategyDevice, RoleStrategyShared, RoleStrategyType:
+	default:
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("unknown role strategy %q. Supported strategies are %s, %s and %s", opts.RoleStrategy, RoleStrategyDevice, RoleStrategyShared, RoleStrategyType))
+	}
+	if opts.SharedRoleName == "" {
+		opts.SharedRoleName = DefaultSharedRoleName
+	}
+	if opts.ConsolidateRoles && opts.RoleStrategy == RoleStrategyDevice {
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("consolidating roles requires the %s or %s role strategy", RoleStrategyShared, RoleStrategyType))
+	}
+	if opts.ConsolidateRoles && !opts.AllowSharedDeviceAccess {
+		return nil, markError(ErrInvalidOptions, errors.New("consolidating roles deletes the per-device roles of the devices; allow it with -allowSharedDeviceAccess"))
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	if opts.Source == nil {
+		switch opts.SourceType {
+		case SourceTypeIotCore, SourceTypeArchive, SourceTypeInventory:
+		default:
+			return nil, markError(ErrInvalidOptions, fmt.Errorf("unknown source type %q. Supported source types are %s, %s and %s", opts.SourceType, SourceTypeIotCore, SourceTypeArchive, SourceTypeInventory))
+		}
+	}
+
+	if opts.Target == nil {
+		switch opts.TargetType {
+		case TargetTypeEnterprise, TargetTypeFile:
+		default:
+			return nil, markError(ErrInvalidOptions, fmt.Errorf("unknown target type %q. Supported target types are %s and %s", opts.TargetType, TargetTypeEnterprise, TargetTypeFile))
+		}
+	}
+
+	return &Migrator{opts: opts, out: opts.Output, log: opts.Logger, source: opts.Source, target: opts.Target}, nil
+}
+
+func checkIotCoreOptions(opts *Options) error {
+	if opts.ServiceAccount == "" || opts.RegistryName == "" || opts.RegistryRegion == "" {
+		return markError(ErrInvalidOptions, errors.New("the service account, registry name and registry region are required to read from ClearBlade IoT Core"))
+	}
+	return nil
+}
+
+// Connect connects to the source and the target. Plan, Migrate and Verify
+// connect on their own, so calling Connect is only needed to check the
+// connections up front.
+func (m *Migrator) Connect() error {
+	if _, err := m.Source(); err != nil {
+		return err
+	}
+	_, err := m.Target()
+	return err
+}
+
+// Source returns the device source, connecting to it on first use.
+func (m *Migrator) Source() (DeviceSource, error) {
+	if m.source != nil {
+		return m.source, nil
+	}
+
+	source, err := m.newDeviceSource()
+	if err != nil {
+		return nil, err
+	}
+	m.source = source
+	return source, nil
+}
+
+// Target returns the device target, connecting to it on first use.
+func (m *Migrator) Target() (DeviceTarget, error) {
+	if m.target != nil {
+		return m.target, nil
+	}
+
+	target, err := m.newDeviceTarget()
+	if err != nil {
+		return nil, err
+	}
+	m.target = target
+	return target, nil
+}
+
+// Close flushes and releases the target.
+func (m *Migrator) Close() error {
+	if m.target == nil {
+		return nil
+	}
+	return m.target.Close()
+}
+
+// connectIotCore creates the ClearBlade IoT Core service and checks that the
+// registry given by the options can be reached.
+func (m *Migrator) connectIotCore() error {
+	if m.service != nil {
+		return nil
+	}
+
+	if err := checkIotCoreOptions(&m.opts); err != nil {
+		return err
+	}
+
+	serviceAccount, err := getAbsPath(m.opts.ServiceAccount)
+	if err != nil {
+		return markError(ErrInvalidOptions, fmt.Errorf("cannot resolve service account path: %w", err))
+	}
+
+	project, err := getCBProjectID(serviceAccount)
+	if err != nil {
+		return markError(ErrInvalidOptions, err)
+	}
+
+	// The IoT Core service only reads the service account from the environment
+	if err := os.Setenv("CLEARBLADE_CONFIGURATION", serviceAccount); err != nil {
+		return fmt.Errorf("error setting CLEARBLADE_CONFIGURATION env variable: %w", err)
+	}
+
+	service, err := cbiotcore.NewService(context.Background())
+	if err != nil {
+		return markError(ErrInvalidOptions, fmt.Errorf("error creating IoT core service interface: %w", err))
+	}
+
+	regDetails, err := cbiotcore.GetRegistryCredentials(m.opts.RegistryName, m.opts.RegistryRegion, service)
+	if err != nil {
+		return markError(ErrSource, fmt.Errorf("error retrieving registry credentials: %w. Please check that the registry name and region are correct", err))
+	}
+
+	if regDetails.SystemKey == "" {
+		return markError(ErrSource, errors.New("unable to fetch ClearBlade registry details. Please check that the registry name and region are correct"))
+	}
+
+	m.service = service
+	m.regDetails = regDetails
+	m.project = project
+	m.registryPath = fmt.Sprintf("projects/%s/locations/%s/registries/%s", project, m.opts.RegistryRegion, m.opts.RegistryName)
+	m.log.Info("Connected to ClearBlade IoT Core", "registry", m.registryPath)
+	return nil
+}
+
+// columnMapping returns the column mapping given by Options.ColumnMapFile,
+// reading the file on first use.
+func (m *Migrator) columnMapping() ([]columnMapping, error) {
+	if m.columnsLoaded || m.opts.ColumnMapFile == "" {
+		return m.columns, nil
+	}
+
+	columns, err := readColumnMap(m.opts.ColumnMapFile)
+	if err != nil {
+		return nil, markError(ErrInvalidOptions, err)
+	}
+	m.columns = columns
+	m.columnsLoaded = true
+	return columns, nil
+}
+
+func (m *Migrator) devicePath(deviceId string) string {
+	return fmt.Sprintf("%s/devices/%s", m.registryPath, deviceId)
+}
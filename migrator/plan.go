@@ -0,0 +1,109 @@
+package migrator
+
+import (
+	"fmt"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// Actions a migration takes for a planned device.
+const (
+	PlanActionCreate = "create"
+	PlanActionUpdate = "update"
+)
+
+// Plan describes what a migration would do without changing the target.
+type Plan struct {
+	Devices []PlannedDevice
+
+	// MissingIds lists the devices of the device list missing from the source.
+	MissingIds []string
+}
+
+// PlannedDevice is a device a migration would create or update.
+type PlannedDevice struct {
+	Id          string
+	Action      string
+	Credentials int
+	Gateway     bool
+
+	// InvalidCredentials holds the reason each credential of the device would
+	// be left out: unsupported, malformed, or expired when the expired
+	// credentials policy is not to migrate them.
+	InvalidCredentials []string
+}
+
+// Creates returns the number of devices the migration would create.
+func (p *Plan) Creates() int {
+	creates := 0
+	for _, device := range p.Devices {
+		if device.Action == PlanActionCreate {
+			creates++
+		}
+	}
+	return creates
+}
+
+// Plan reads the devices selected by the options from the source and checks
+// which of them already exist in the target. Nothing is written to the target.
+func (m *Migrator) Plan() (*Plan, error) {
+	source, err := m.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := m.Target()
+	if err != nil {
+		return nil, err
+	}
+
+	devices, _, missingIds, err := m.collectDevices(source)
+	if err != nil {
+		return nil, markError(ErrSource, fmt.Errorf("error fetching devices: %w", err))
+	}
+
+	plan := &Plan{
+		Devices:    make([]PlannedDevice, 0, len(devices)),
+		MissingIds: missingIds,
+	}
+
+	for _, device := range devices {
+		planned, err := m.planDevice(source, target, device)
+		if err != nil {
+			return nil, err
+		}
+		m.log.Debug("Planned device", "device", planned.Id, "action", planned.Action, "credentials", planned.Credentials)
+		plan.Devices = append(plan.Devices, planned)
+	}
+
+	return plan, nil
+}
+
+func (m *Migrator) planDevice(source DeviceSource, target DeviceTarget, device *cbiotcore.Device) (PlannedDevice, error) {
+	planned := PlannedDevice{
+		Id:      device.Id,
+		Action:  PlanActionCreate,
+		Gateway: isGateway(device),
+	}
+
+	existing, err := target.GetDevice(device.Id)
+	if err != nil {
+		return planned, fmt.Errorf("error fetching device %s from target: %w", device.Id, err)
+	}
+	if existing != nil {
+		planned.Action = PlanActionUpdate
+	}
+
+	credentials, err := source.Credentials(device)
+	if err != nil {
+		return planned, fmt.Errorf("error fetching credentials of device %s: %w", device.Id, err)
+	}
+	planned.Credentials = len(credentials)
+	for _, check := range m.checkDeviceCredentials(device.Id, credentials) {
+		if check.Err != nil {
+			planned.InvalidCredentials = append(planned.InvalidCredentials, check.Err.Error())
+		}
+	}
+
+	return planned, nil
+}
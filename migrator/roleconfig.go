@@ -0,0 +1,291 @@
+package migrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	cb "github.com/clearblade/Go-SDK"
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// RoleConfig holds the permissions granted to the device roles.
+type RoleConfig struct {
+	Topics      []TopicPermission      `json:"topics"`
+	Collections []CollectionPermission `json:"collections,omitempty"`
+	Services    []ServicePermission    `json:"services,omitempty"`
+
+	// Devices grants permissions on the whole devices table. IoT Enterprise
+	// roles cannot scope them to the row of the device, so read lets every
+	// device read every device row, and update or delete lets it change them.
+	// They require Options.AllowSharedDeviceAccess.
+	Devices *TablePermission `json:"devices,omitempty"`
+}
+
+// hasPlatformPermissions reports whether the configuration grants permissions
+// other than topics, which the target must implement RolePermissionTarget for.
+func (c *RoleConfig) hasPlatformPermissions() bool {
+	return len(c.Collections) > 0 || len(c.Services) > 0 || c.Devices != nil
+}
+
+// TopicPermission grants permissions on the topic rendered from a template
+// for every device. The template may hold the {device_id}, {type},
+// {registry}, {region} and {project} tokens, and {metadata.<key>} for a
+// metadata value of the device.
+type TopicPermission struct {
+	Topic string `json:"topic"`
+
+	// Permissions are any of read (or subscribe), create (or publish),
+	// update and delete.
+	Permissions []string `json:"permissions"`
+}
+
+// CollectionPermission grants permissions on the data collection with the
+// given ID.
+type CollectionPermission struct {
+	Collection  string   `json:"collection"`
+	Permissions []string `json:"permissions"`
+}
+
+// ServicePermission grants permissions on the code service with the given
+// name. Besides the topic permissions, services accept execute, the same
+// permission as read.
+type ServicePermission struct {
+	Service     string   `json:"service"`
+	Permissions []string `json:"permissions"`
+}
+
+// TablePermission grants permissions on every row of a system table.
+type TablePermission struct {
+	Permissions []string `json:"permissions"`
+}
+
+// defaultRoleConfig mirrors the topic layout of ClearBlade IoT Core.
+var defaultRoleConfig = RoleConfig{
+	Topics: []TopicPermission{
+		{Topic: "/devices/{device_id}/commands/#", Permissions: []string{"read"}},
+		{Topic: "/devices/{device_id}/config", Permissions: []string{"read"}},
+		{Topic: "/devices/{device_id}/errors", Permissions: []string{"read"}},
+		{Topic: "/devices/{device_id}/events/#", Permissions: []string{"create"}},
+		{Topic: "/devices/{device_id}/state", Permissions: []string{"create"}},
+	},
+}
+
+// permissionLevels maps permission names to IoT Enterprise permission bits.
+var permissionLevels = map[string]int{
+	"read":      cb.PERM_READ,
+	"subscribe": cb.PERM_READ,
+	"create":    cb.PERM_CREATE,
+	"publish":   cb.PERM_CREATE,
+	"update":    cb.PERM_UPDATE,
+	"delete":    cb.PERM_DELETE,
+}
+
+// permissionLevel combines permission names into a permission level.
+func permissionLevel(permissions []string) (int, error) {
+	if len(permissions) == 0 {
+		return 0, fmt.Errorf("no permissions")
+	}
+
+	level := 0
+	for _, permission := range permissions {
+		bit, ok := permissionLevels[strings.ToLower(strings.TrimSpace(permission))]
+		if !ok {
+			return 0, fmt.Errorf("unknown permission %q", permission)
+		}
+		level |= bit
+	}
+	return level, nil
+}
+
+// servicePermissionLevel combines service permission names into a permission
+// level, taking execute as read.
+func servicePermissionLevel(permissions []string) (int, error) {
+	names := make([]string, len(permissions))
+	for i, permission := range permissions {
+		if strings.EqualFold(strings.TrimSpace(permission), "execute") {
+			permission = "read"
+		}
+		names[i] = permission
+	}
+	return permissionLevel(names)
+}
+
+// Tokens of the role templates.
+const (
+	tokenDeviceId = "device_id"
+	tokenType     = "type"
+	tokenRegistry = "registry"
+	tokenRegion   = "region"
+	tokenProject  = "project"
+
+	metadataTokenPrefix = "metadata."
+)
+
+var templateToken = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// checkTemplate checks that a template only holds known tokens.
+func checkTemplate(template string) error {
+	for _, match := range templateToken.FindAllStringSubmatch(template, -1) {
+		switch token := match[1]; {
+		case token == tokenDeviceId, token == tokenType, token == tokenRegistry, token == tokenRegion, token == tokenProject:
+		case strings.HasPrefix(token, metadataTokenPrefix) && len(token) > len(metadataTokenPrefix):
+		default:
+			return fmt.Errorf("unknown token {%s}", token)
+		}
+	}
+	return nil
+}
+
+// deviceScopedToken reports whether a token takes the value of a single
+// device, {device_id} or a metadata value.
+func deviceScopedToken(token string) bool {
+	return token == tokenDeviceId || strings.HasPrefix(token, metadataTokenPrefix)
+}
+
+// renderTemplate replaces the tokens of a template with their values. It
+// fails when a token has no value, such as a metadata key the device lacks,
+// unless wildcard is set and the token is device scoped, in which case it
+// becomes the single level MQTT wildcard.
+func renderTemplate(template string, values map[string]string, wildcard bool) (string, error) {
+	var missing []string
+	rendered := templateToken.ReplaceAllStringFunc(template, func(match string) string {
+		token := match[1 : len(match)-1]
+		value, ok := values[token]
+		if ok && value != "" {
+			return value
+		}
+		if wildcard && deviceScopedToken(token) {
+			return "+"
+		}
+		missing = append(missing, match)
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for %s in template %s", strings.Join(missing, ", "), template)
+	}
+	return rendered, nil
+}
+
+// readRoleConfig reads a role configuration JSON file and checks every
+// template and permission.
+func readRoleConfig(configFile string) (*RoleConfig, error) {
+	absPath, err := getAbsPath(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve role configuration filepath: %w", err)
+	}
+
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read role configuration: %w", err)
+	}
+
+	var config RoleConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse role configuration %s: %w", configFile, err)
+	}
+
+	for i, topic := range config.Topics {
+		if strings.TrimSpace(topic.Topic) == "" {
+			return nil, fmt.Errorf("role configuration topic %d: missing topic", i)
+		}
+		if err := checkTemplate(topic.Topic); err != nil {
+			return nil, fmt.Errorf("role configuration topic %q: %w", topic.Topic, err)
+		}
+		if _, err := permissionLevel(topic.Permissions); err != nil {
+			return nil, fmt.Errorf("role configuration topic %q: %w", topic.Topic, err)
+		}
+	}
+	for i, collection := range config.Collections {
+		if strings.TrimSpace(collection.Collection) == "" {
+			return nil, fmt.Errorf("role configuration collection %d: missing collection", i)
+		}
+		if _, err := permissionLevel(collection.Permissions); err != nil {
+			return nil, fmt.Errorf("role configuration collection %q: %w", collection.Collection, err)
+		}
+	}
+	for i, service := range config.Services {
+		if strings.TrimSpace(service.Service) == "" {
+			return nil, fmt.Errorf("role configuration service %d: missing service", i)
+		}
+		if _, err := servicePermissionLevel(service.Permissions); err != nil {
+			return nil, fmt.Errorf("role configuration service %q: %w", service.Service, err)
+		}
+	}
+	if config.Devices != nil {
+		if _, err := permissionLevel(config.Devices.Permissions); err != nil {
+			return nil, fmt.Errorf("role configuration devices: %w", err)
+		}
+	}
+	return &config, nil
+}
+
+// checkRegistryTokens checks that every registry token of the topic templates
+// has a value, as an inventory source, or an archive without these fields in
+// its manifest, cannot supply them.
+func checkRegistryTokens(config *RoleConfig, values map[string]string) error {
+	for _, permission := range config.Topics {
+		for _, match := range templateToken.FindAllStringSubmatch(permission.Topic, -1) {
+			switch token := match[1]; token {
+			case tokenRegistry, tokenRegion, tokenProject:
+				if values[token] == "" {
+					return fmt.Errorf("role configuration topic %q: the source has no {%s} value", permission.Topic, token)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// roleConfig returns the configuration given by Options.RoleConfigFile,
+// reading the file on first use, or the default configuration. The source
+// must be set, as the registry tokens are checked against it.
+func (m *Migrator) roleConfig() (*RoleConfig, error) {
+	if m.roles != nil {
+		return m.roles, nil
+	}
+	if m.opts.RoleConfigFile == "" {
+		return &defaultRoleConfig, nil
+	}
+
+	config, err := readRoleConfig(m.opts.RoleConfigFile)
+	if err != nil {
+		return nil, markError(ErrInvalidOptions, err)
+	}
+	if err := checkRegistryTokens(config, m.registryValues()); err != nil {
+		return nil, markError(ErrInvalidOptions, err)
+	}
+	m.roles = config
+	return config, nil
+}
+
+// registryValues returns the values of the registry template tokens, taken
+// from the archive manifest when migrating an archive and from the options
+// otherwise.
+func (m *Migrator) registryValues() map[string]string {
+	if source, ok := m.source.(*archiveSource); ok {
+		return map[string]string{
+			tokenRegistry: source.archive.Manifest.Registry,
+			tokenRegion:   source.archive.Manifest.Region,
+			tokenProject:  source.archive.Manifest.Project,
+		}
+	}
+	return map[string]string{
+		tokenRegistry: m.opts.RegistryName,
+		tokenRegion:   m.opts.RegistryRegion,
+		tokenProject:  m.project,
+	}
+}
+
+// templateValues returns the values of the template tokens for a device.
+func (m *Migrator) templateValues(device *cbiotcore.Device, deviceType string) map[string]string {
+	values := m.registryValues()
+	values[tokenDeviceId] = device.Id
+	values[tokenType] = deviceType
+	for key, value := range device.Metadata {
+		values[metadataTokenPrefix+key] = value
+	}
+	return values
+}
@@ -0,0 +1,145 @@
+package migrator
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cb "github.com/clearblade/Go-SDK"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	values := map[string]string{"device_id": "device-1", "registry": "registry", "metadata.site": "plant-1"}
+
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "/devices/{device_id}/events/#", want: "/devices/device-1/events/#"},
+		{template: "/{registry}/{metadata.site}/{device_id}", want: "/registry/plant-1/device-1"},
+		{template: "/sites/{metadata.line}/{device_id}", wantErr: true},
+		{template: "/fixed/topic", want: "/fixed/topic"},
+	}
+
+	for _, tt := range tests {
+		got, err := renderTemplate(tt.template, values, false)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("renderTemplate(%q) = %q, %v, want %q", tt.template, got, err, tt.want)
+		}
+	}
+
+	// Shared roles cannot hold device values, so they match any device
+	shared := map[string]string{"registry": "registry"}
+	if got, err := renderTemplate("/{registry}/{metadata.site}/{device_id}/#", shared, true); err != nil || got != "/registry/+/+/#" {
+		t.Errorf("wildcard rendering = %q, %v, want /registry/+/+/#", got, err)
+	}
+	if _, err := renderTemplate("/{project}/{device_id}", shared, true); err == nil {
+		t.Error("wildcard rendering of a registry token without value: no error")
+	}
+}
+
+func TestCheckSharedRoleConfig(t *testing.T) {
+	config := func(topics ...string) *RoleConfig {
+		c := &RoleConfig{}
+		for _, topic := range topics {
+			c.Topics = append(c.Topics, TopicPermission{Topic: topic, Permissions: []string{"read"}})
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		allow    bool
+		config   *RoleConfig
+		wantErr  bool
+	}{
+		{name: "no device tokens", strategy: RoleStrategyShared, config: config("/{registry}/broadcast", "/fleet-{registry}/#")},
+		{name: "type token", strategy: RoleStrategyType, config: config("/types/{type}/#")},
+		{name: "type token of shared role", strategy: RoleStrategyShared, config: config("/types/{type}/#"), wantErr: true},
+		{name: "device token without opt-in", strategy: RoleStrategyShared, config: &defaultRoleConfig, wantErr: true},
+		{name: "device token with opt-in", strategy: RoleStrategyType, allow: true, config: &defaultRoleConfig},
+		{name: "partial level", strategy: RoleStrategyShared, allow: true, config: config("/dev-{device_id}/events"), wantErr: true},
+		{name: "partial metadata level", strategy: RoleStrategyShared, allow: true, config: config("/sites/{metadata.site}.{metadata.line}"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		m := &Migrator{opts: Options{RoleStrategy: tt.strategy, AllowSharedDeviceAccess: tt.allow}, log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+		if err := m.checkSharedRoleConfig(tt.config); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadRoleConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "roles.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	config, err := readRoleConfig(write(`{"topics": [{"topic": "/{region}/{device_id}/#", "permissions": ["subscribe", "publish"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level, _ := permissionLevel(config.Topics[0].Permissions); level != cb.PERM_READ|cb.PERM_CREATE {
+		t.Errorf("permission level = %d, want %d", level, cb.PERM_READ|cb.PERM_CREATE)
+	}
+
+	config, err = readRoleConfig(write(`{"topics": [], "services": [{"service": "lookup", "permissions": ["execute"]}], "devices": {"permissions": ["read"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level, _ := servicePermissionLevel(config.Services[0].Permissions); level != cb.PERM_READ || !config.hasPlatformPermissions() {
+		t.Errorf("service permission level = %d, want %d", level, cb.PERM_READ)
+	}
+
+	invalid := map[string]string{
+		"unknown token":      `{"topics": [{"topic": "/{tenant}/{device_id}", "permissions": ["read"]}]}`,
+		"unknown permission": `{"topics": [{"topic": "/{device_id}", "permissions": ["execute"]}]}`,
+		"no permissions":     `{"topics": [{"topic": "/{device_id}"}]}`,
+		"empty topic":        `{"topics": [{"topic": " ", "permissions": ["read"]}]}`,
+		"not json":           `topics`,
+		"empty collection":   `{"topics": [], "collections": [{"collection": "", "permissions": ["read"]}]}`,
+		"no service perms":   `{"topics": [], "services": [{"service": "lookup"}]}`,
+		"execute devices":    `{"topics": [], "devices": {"permissions": ["execute"]}}`,
+	}
+	for name, content := range invalid {
+		if _, err := readRoleConfig(write(content)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	for _, topic := range defaultRoleConfig.Topics {
+		if err := checkTemplate(topic.Topic); err != nil || !strings.Contains(topic.Topic, "{device_id}") {
+			t.Errorf("default topic %q: %v", topic.Topic, err)
+		}
+	}
+}
+
+func TestRoleConfigRegistryTokens(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "roles.json")
+	content := `{"topics": [{"topic": "/{project}/{registry}/{device_id}", "permissions": ["read"]}]}`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inventories have no project, so the tokens must come from the options
+	m := &Migrator{opts: Options{RoleConfigFile: configFile, RegistryName: "registry"}, source: &inventorySource{}}
+	if _, err := m.roleConfig(); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("inventory source error = %v, want %v", err, ErrInvalidOptions)
+	}
+
+	m = &Migrator{opts: Options{RoleConfigFile: configFile, RegistryName: "registry"}, source: &inventorySource{}, project: "project"}
+	if _, err := m.roleConfig(); err != nil {
+		t.Errorf("source with every registry value: %v", err)
+	}
+}
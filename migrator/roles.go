@@ -0,0 +1,473 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// Role strategies, selecting which role a migrated device is assigned.
+const (
+	// RoleStrategyDevice gives every device a role named after it, with the
+	// permissions on its own topics.
+	RoleStrategyDevice = "device"
+
+	// RoleStrategyShared assigns every device the same role.
+	RoleStrategyShared = "shared"
+
+	// RoleStrategyType assigns every device the role of its device type.
+	RoleStrategyType = "type"
+)
+
+// DefaultSharedRoleName is the name of the shared device role, and the prefix
+// of the device type roles.
+const DefaultSharedRoleName = "devices"
+
+// RoleConsolidationTarget is implemented by targets that can take roles away
+// from devices and delete roles, which consolidating per-device roles into
+// shared roles requires.
+type RoleConsolidationTarget interface {
+	RemoveDeviceFromRoles(deviceName string, roles []string) error
+	DeleteRole(roleId string) error
+}
+
+// RolePermissionTarget is implemented by targets that can grant roles
+// permissions on data collections, code services and the devices table, which
+// role configurations granting such permissions require.
+type RolePermissionTarget interface {
+	AddCollectionToRole(collectionId, roleId string, level int) error
+	AddServiceToRole(service, roleId string, level int) error
+
+	// AddDevicesToRole grants the role permissions on every row of the
+	// devices table.
+	AddDevicesToRole(roleId string, level int) error
+}
+
+// checkSharedRoleConfig checks that the topic templates of a role
+// configuration can be rendered for the shared roles of the role strategy.
+// Device scoped tokens become wildcards that grant every device the topics of
+// every other device, so they need Options.AllowSharedDeviceAccess, and must
+// fill a whole topic level to be valid wildcards.
+func (m *Migrator) checkSharedRoleConfig(config *RoleConfig) error {
+	if m.opts.RoleStrategy == RoleStrategyDevice {
+		return nil
+	}
+
+	var scoped []string
+	for _, permission := range config.Topics {
+		for _, level := range strings.Split(permission.Topic, "/") {
+			for _, match := range templateToken.FindAllStringSubmatch(level, -1) {
+				token := match[1]
+				if token == tokenType && m.opts.RoleStrategy == RoleStrategyShared {
+					return fmt.Errorf("role configuration topic %q: the %s role strategy has no {type} value, use the %s strategy", permission.Topic, RoleStrategyShared, RoleStrategyType)
+				}
+				if !deviceScopedToken(token) {
+					continue
+				}
+				if level != match[0] {
+					return fmt.Errorf("role configuration topic %q: {%s} must fill a whole topic level to become a wildcard in %s roles", permission.Topic, token, m.opts.RoleStrategy)
+				}
+				scoped = append(scoped, permission.Topic)
+			}
+		}
+	}
+
+	if len(scoped) == 0 {
+		return nil
+	}
+	if !m.opts.AllowSharedDeviceAccess {
+		return fmt.Errorf("the %s role strategy renders {device_id} and metadata tokens as wildcards, giving every device the topics of every other device (%s); allow it with -allowSharedDeviceAccess", m.opts.RoleStrategy, strings.Join(slices.Compact(scoped), ", "))
+	}
+	m.log.Warn("Shared device roles give every device the topics of every other device", "strategy", m.opts.RoleStrategy, "topics", slices.Compact(scoped))
+	return nil
+}
+
+// sharedRole is a role set up once for every device assigned to it. errors
+// holds the failed steps of the setup, recorded for each of these devices.
+type sharedRole struct {
+	once   sync.Once
+	errors []ErrorLog
+}
+
+// migrateDeviceRole creates the role of a device that was already migrated and
+// assigns it to the device, whether or not the device has credentials.
+func (m *Migrator) migrateDeviceRole(result *DeviceResult, source DeviceSource, device *cbiotcore.Device, overrides map[string]interface{}) {
+	credentials, err := source.Credentials(device)
+	if err != nil {
+		result.fail("Error when fetching device credentials", err)
+		return
+	}
+
+	result.Keyless = len(credentials) == 0
+	_ = m.createDeviceRole(result, device, overrides)
+}
+
+// deviceType returns the type column a migration writes for a device.
+func (m *Migrator) deviceType(device *cbiotcore.Device, overrides map[string]interface{}) string {
+	deviceType, _ := transform(device, m.opts.DeviceType, deviceAuth{}, m.columns, overrides)["type"].(string)
+	return deviceType
+}
+
+// deviceRoleName returns the name of the role a device is assigned under the
+// role strategy of the options.
+func (m *Migrator) deviceRoleName(device *cbiotcore.Device, overrides map[string]interface{}) string {
+	switch m.opts.RoleStrategy {
+	case RoleStrategyShared:
+		return m.opts.SharedRoleName
+	case RoleStrategyType:
+		if deviceType := m.deviceType(device, overrides); deviceType != "" {
+			return m.opts.SharedRoleName + "-" + deviceType
+		}
+		return m.opts.SharedRoleName
+	}
+	return device.Id
+}
+
+// createDeviceRole sets up the role of a device with the permissions of the
+// role configuration, and assigns it to the device. Shared roles are set up
+// by the first device assigned to them.
+func (m *Migrator) createDeviceRole(result *DeviceResult, device *cbiotcore.Device, overrides map[string]interface{}) error {
+	roleName := m.deviceRoleName(device, overrides)
+	deviceType := m.deviceType(device, overrides)
+	m.log.Debug("Creating device role", "device", device.Id, "step", "role", "role", roleName)
+
+	if m.opts.RoleStrategy == RoleStrategyDevice {
+		roleId, err := m.setupRole(result, roleName)
+		if err != nil {
+			return err
+		}
+		if err := m.addTopicsToRole(result, roleId, m.templateValues(device, deviceType), false); err != nil {
+			return err
+		}
+		if err := m.addPlatformPermissionsToRole(result, roleId); err != nil {
+			return err
+		}
+	} else if err := m.setupSharedRole(result, roleName, deviceType); err != nil {
+		return err
+	}
+
+	if err := m.addDeviceToRole(result, device, roleName); err != nil {
+		return err
+	}
+
+	if m.opts.ConsolidateRoles && roleName != device.Id {
+		return m.consolidateDeviceRole(result, device, deviceType)
+	}
+	return nil
+}
+
+// setupSharedRole sets up a shared role the first time a device is assigned
+// to it, and records the failed steps of the setup for every such device.
+func (m *Migrator) setupSharedRole(result *DeviceResult, roleName, deviceType string) error {
+	m.sharedRolesMu.Lock()
+	if m.sharedRoles == nil {
+		m.sharedRoles = make(map[string]*sharedRole)
+	}
+	role, ok := m.sharedRoles[roleName]
+	if !ok {
+		role = &sharedRole{}
+		m.sharedRoles[roleName] = role
+	}
+	m.sharedRolesMu.Unlock()
+
+	role.once.Do(func() {
+		m.log.Info("Creating shared device role", "role", roleName)
+		setup := &DeviceResult{DeviceId: result.DeviceId}
+
+		// Shared roles cannot hold the values of a single device
+		values := m.registryValues()
+		if m.opts.RoleStrategy == RoleStrategyType {
+			values[tokenType] = deviceType
+		}
+		if roleId, err := m.setupRole(setup, roleName); err == nil {
+			if err := m.addTopicsToRole(setup, roleId, values, true); err == nil {
+				_ = m.addPlatformPermissionsToRole(setup, roleId)
+			}
+		}
+		role.errors = setup.Errors
+	})
+
+	var err error
+	for _, errorLog := range role.errors {
+		err = result.fail(errorLog.Context, fmt.Errorf("shared role %s: %w", roleName, errorLog.Error))
+	}
+	return err
+}
+
+// setupRole creates a role, or finds it when it already exists, and returns
+// its ID.
+func (m *Migrator) setupRole(result *DeviceResult, roleName string) (string, error) {
+	role, err := m.createRole(result, roleName)
+	if err != nil {
+		return "", err
+	}
+
+	roleId := getRoleId(role)
+	if roleId == "" {
+		return "", result.fail("Error when Creating role", markError(ErrUnexpectedResponse, fmt.Errorf("role %s has no ID: %v", roleName, role)))
+	}
+	return roleId, nil
+}
+
+// getRoleId returns the ID of a role, which a role create answers as role_id
+// and a role query as ID, or an empty string when the role has no ID.
+func getRoleId(role map[string]interface{}) string {
+	if roleId, ok := role["role_id"].(string); ok && roleId != "" {
+		return roleId
+	}
+	roleId, _ := role["ID"].(string)
+	return roleId
+}
+
+// duplicateRoleMessage is the error message of the bad request IoT Enterprise
+// answers a role create with when the role exists.
+const duplicateRoleMessage = "A role's name must be unique"
+
+// isDuplicateRole reports whether a role create failed because the role
+// exists. IoT Enterprise answers it with a bad request, which is told apart
+// from other bad requests by the message of its error body.
+func isDuplicateRole(err error) bool {
+	if IsConflict(err) {
+		return true
+	}
+
+	var apiErr *APIError
+	return hasStatus(err, http.StatusBadRequest) && errors.As(err, &apiErr) && apiErr.Message == duplicateRoleMessage
+}
+
+func (m *Migrator) createRole(result *DeviceResult, roleName string) (map[string]interface{}, error) {
+	role, err := m.target.CreateRole(roleName)
+	if err == nil {
+		if created, ok := role.(map[string]interface{}); ok && created != nil {
+			return created, nil
+		}
+		err = markError(ErrUnexpectedResponse, fmt.Errorf("unexpected response when creating role %s: %v", roleName, role))
+	}
+
+	// The role is looked up when it exists, and when the response of the
+	// create cannot be used. Other failures, such as auth or network errors
+	// or invalid roles, are reported as is.
+	if !isDuplicateRole(err) && !errors.Is(err, ErrUnexpectedResponse) {
+		return nil, result.fail("Error when Creating role", err)
+	}
+	existing, getErr := m.target.GetRole(roleName)
+	if getErr == nil {
+		return existing, nil
+	}
+
+	if IsConflict(err) {
+		return nil, result.fail("Error when retrieving role", getErr)
+	}
+
+	return nil, result.fail("Error when Creating role", err)
+}
+
+// addTopicsToRole grants the role the permissions of the role configuration
+// on the topics rendered from values. Shared roles render the tokens without
+// a value as wildcards.
+func (m *Migrator) addTopicsToRole(result *DeviceResult, roleId string, values map[string]string, shared bool) error {
+	config, err := m.roleConfig()
+	if err != nil {
+		return result.fail("Error when adding topic to role", err)
+	}
+
+	for _, permission := range config.Topics {
+		topic, err := renderTemplate(permission.Topic, values, shared)
+		if err != nil {
+			return result.fail("Error when adding topic to role", err)
+		}
+
+		// Permissions were checked when the configuration was read
+		level, _ := permissionLevel(permission.Permissions)
+		if err := m.target.AddTopicToRole(topic, roleId, level); err != nil {
+			return result.fail("Error when adding topic to role", err)
+		}
+	}
+	return nil
+}
+
+// addPlatformPermissionsToRole grants the role the collection, service and
+// devices table permissions of the role configuration.
+func (m *Migrator) addPlatformPermissionsToRole(result *DeviceResult, roleId string) error {
+	config, err := m.roleConfig()
+	if err != nil {
+		return result.fail("Error when adding permissions to role", err)
+	}
+	if !config.hasPlatformPermissions() {
+		return nil
+	}
+
+	// Run checks the target supports these permissions before any device
+	// migrates, and permissions were checked when the configuration was read
+	target := m.target.(RolePermissionTarget)
+	for _, permission := range config.Collections {
+		level, _ := permissionLevel(permission.Permissions)
+		if err := target.AddCollectionToRole(permission.Collection, roleId, level); err != nil {
+			return result.fail("Error when adding collection to role", err)
+		}
+	}
+	for _, permission := range config.Services {
+		level, _ := servicePermissionLevel(permission.Permissions)
+		if err := target.AddServiceToRole(permission.Service, roleId, level); err != nil {
+			return result.fail("Error when adding service to role", err)
+		}
+	}
+	if config.Devices != nil {
+		level, _ := permissionLevel(config.Devices.Permissions)
+		if err := target.AddDevicesToRole(roleId, level); err != nil {
+			return result.fail("Error when adding devices table to role", err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) addDeviceToRole(result *DeviceResult, device *cbiotcore.Device, roleName string) error {
+	err := m.target.AddDeviceToRoles(device.Id, []string{roleName})
+	if err != nil && !IsConflict(err) && !m.deviceHasRole(device.Id, roleName) {
+		return result.fail("Error when Creating role", err)
+	}
+	return nil
+}
+
+// consolidateDeviceRole takes the role named after a device away from it and
+// deletes the role, once the device has its shared role. Only a per-device
+// role of an earlier migration is deleted: the device must hold it, it must
+// grant nothing but the topics of the device, and it must not be a shared role
+// of this migration. Any other role named after the device is left as it is.
+func (m *Migrator) consolidateDeviceRole(result *DeviceResult, device *cbiotcore.Device, deviceType string) error {
+	// Run checks the target supports consolidation before any device migrates
+	target := m.target.(RoleConsolidationTarget)
+
+	if !m.deviceHasRole(device.Id, device.Id) {
+		return nil
+	}
+	if m.isSharedRole(device.Id) {
+		m.log.Warn("Not consolidating role, the role named after the device is a shared role", "device", device.Id, "step", "role")
+		return nil
+	}
+
+	role, err := m.target.GetRole(device.Id)
+	if IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return result.fail("Error when consolidating role", err)
+	}
+	perDevice, err := m.isPerDeviceRole(role, device, deviceType)
+	if err != nil {
+		return result.fail("Error when consolidating role", err)
+	}
+	if !perDevice {
+		m.log.Warn("Not consolidating role, the role named after the device grants more than the topics of the device", "device", device.Id, "step", "role")
+		return nil
+	}
+	roleId := getRoleId(role)
+	if roleId == "" {
+		return result.fail("Error when consolidating role", markError(ErrUnexpectedResponse, fmt.Errorf("role %s has no ID: %v", device.Id, role)))
+	}
+
+	m.log.Debug("Removing per-device role", "device", device.Id, "step", "role")
+	if err := target.RemoveDeviceFromRoles(device.Id, []string{device.Id}); err != nil {
+		return result.fail("Error when consolidating role", err)
+	}
+
+	m.log.Info("Deleting per-device role", "device", device.Id, "step", "role")
+	if err := target.DeleteRole(roleId); err != nil && !IsNotFound(err) {
+		return result.fail("Error when consolidating role", err)
+	}
+	return nil
+}
+
+// isSharedRole reports whether a shared role of this migration has the name.
+func (m *Migrator) isSharedRole(roleName string) bool {
+	m.sharedRolesMu.Lock()
+	defer m.sharedRolesMu.Unlock()
+
+	_, ok := m.sharedRoles[roleName]
+	return ok
+}
+
+// isPerDeviceRole reports whether a role, as returned by GetRole, grants
+// nothing but permissions on the topics the role configuration renders for
+// the device. A role without a permission list is not one.
+func (m *Migrator) isPerDeviceRole(role map[string]interface{}, device *cbiotcore.Device, deviceType string) (bool, error) {
+	permissions, ok := role["Permissions"].(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+
+	config, err := m.roleConfig()
+	if err != nil {
+		return false, err
+	}
+	deviceTopics := make(map[string]bool, len(config.Topics))
+	for _, permission := range config.Topics {
+		topic, err := renderTemplate(permission.Topic, m.templateValues(device, deviceType), false)
+		if err != nil {
+			return false, err
+		}
+		deviceTopics[topic] = true
+	}
+
+	for kind, granted := range permissions {
+		if kind != "Topics" {
+			if !isEmptyPermission(granted) {
+				return false, nil
+			}
+			continue
+		}
+
+		topics, ok := granted.([]interface{})
+		if !ok && granted != nil {
+			return false, nil
+		}
+		for _, topic := range topics {
+			fields, _ := topic.(map[string]interface{})
+			if name, _ := fields["Name"].(string); !deviceTopics[name] {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// isEmptyPermission reports whether a permission of a role, decoded from
+// JSON, grants nothing: an empty list, or a level of zero.
+func isEmptyPermission(granted interface{}) bool {
+	switch granted := granted.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(granted) == 0
+	case map[string]interface{}:
+		level, ok := granted["Level"].(float64)
+		return ok && level == 0
+	case float64:
+		return granted == 0
+	case bool:
+		return !granted
+	}
+	return false
+}
+
+// deviceHasRole reports whether a device was already assigned a role. Some IoT
+// Enterprise versions answer a duplicate role assignment with a server error
+// rather than a conflict, so a failed assignment is checked this way before it
+// is reported.
+func (m *Migrator) deviceHasRole(deviceId, roleName string) bool {
+	roles, err := m.target.GetDeviceRoles(deviceId)
+	if err != nil {
+		return false
+	}
+	for _, role := range roles {
+		if role == roleName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package migrator
+
+import (
+	"fmt"
+	"strings"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// Source types supported by Options.SourceType.
+const (
+	SourceTypeIotCore   = "iotcore"
+	SourceTypeArchive   = "archive"
+	SourceTypeInventory = "inventory"
+)
+
+// DeviceSource is an origin devices can be migrated from. Devices are always
+// described with the ClearBlade IoT Core device model so that every source can
+// feed the same migration pipeline.
+type DeviceSource interface {
+	// Count returns the number of devices in the source.
+	Count() (int, error)
+
+	// List sends every device in the source to devicesC.
+	List(devicesC chan<- *cbiotcore.Device) error
+
+	// GetByIds sends the devices with the given IDs to devicesC and returns
+	// the IDs that were not found, in the order they were given.
+	GetByIds(deviceIds []string, devicesC chan<- *cbiotcore.Device) ([]string, error)
+
+	// Credentials returns the public key credentials of a device.
+	Credentials(device *cbiotcore.Device) ([]*cbiotcore.DeviceCredential, error)
+
+	// Config returns the latest configuration of a device, or nil if it has none.
+	Config(device *cbiotcore.Device) (*cbiotcore.DeviceConfig, error)
+
+	// Bindings returns the IDs of the devices bound to a gateway.
+	Bindings(device *cbiotcore.Device) ([]string, error)
+}
+
+// newDeviceSource creates the source selected by Options.SourceType.
+func (m *Migrator) newDeviceSource() (DeviceSource, error) {
+	switch m.opts.SourceType {
+	case SourceTypeIotCore:
+		if err := m.connectIotCore(); err != nil {
+			return nil, err
+		}
+		return m.newIotCoreSource(), nil
+	case SourceTypeArchive, SourceTypeInventory:
+		if m.opts.SourcePath == "" {
+			return nil, markError(ErrInvalidOptions, fmt.Errorf("a source path is required for source type %s", m.opts.SourceType))
+		}
+
+		absPath, err := getAbsPath(m.opts.SourcePath)
+		if err != nil {
+			return nil, markError(ErrInvalidOptions, fmt.Errorf("cannot resolve source path: %w", err))
+		}
+		if m.opts.SourceType == SourceTypeArchive {
+			source, err := newArchiveSource(m.out, absPath)
+			if err != nil {
+				return nil, markError(ErrSource, err)
+			}
+			return source, nil
+		}
+
+		source, err := newInventorySource(absPath)
+		if err != nil {
+			return nil, markError(ErrSource, err)
+		}
+		return source, nil
+	default:
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("unknown source type %q. Supported source types are %s, %s and %s", m.opts.SourceType, SourceTypeIotCore, SourceTypeArchive, SourceTypeInventory))
+	}
+}
+
+// migrateDevicesFromSource runs migrate for either the devices given in the
+// device list, or every device of the source when no list is given. When the
+// source fails after the migration started, the devices read until then are
+// still migrated and their result is returned along with the error.
+func (m *Migrator) migrateDevicesFromSource(source DeviceSource, deviceCount int, migrate deviceMigration) (*Result, error) {
+	devicesC := make(chan *cbiotcore.Device, m.opts.PageSize)
+
+	var missingIds []string
+	var sourceErr error
+	total := deviceCount
+
+	entries, err := m.deviceList()
+	if err != nil {
+		return nil, err
+	}
+
+	if entries != nil {
+		total = len(entries)
+		go func() {
+			defer close(devicesC)
+			missingIds, sourceErr = source.GetByIds(getDeviceListIds(entries), devicesC)
+		}()
+	} else {
+		fmt.Fprintln(m.out, string(colorGreen), "\u2713 Fetching all", deviceCount, "devices!", string(colorReset))
+		devices, err := listAllDevices(source)
+		if err != nil {
+			return nil, markError(ErrSource, fmt.Errorf("error fetching all devices: %w", err))
+		}
+		fmt.Fprintln(m.out, string(colorGreen), "\u2713 Fetched", len(devices), "devices", string(colorReset))
+
+		// The device count reported by a source can be stale, so size the
+		// migration from the devices actually listed
+		total = len(devices)
+		devicesC = make(chan *cbiotcore.Device, len(devices))
+		for _, device := range devices {
+			devicesC <- device
+		}
+		close(devicesC)
+	}
+
+	result := m.migrateDevicesToClearBlade(source, devicesC, total, getDeviceListOverrides(entries), migrate)
+	result.MissingIds = missingIds
+
+	if sourceErr != nil {
+		return result, markError(ErrSource, fmt.Errorf("error fetching devices: %w", sourceErr))
+	}
+
+	if entries != nil {
+		successMsg := "Fetched " + fmt.Sprint(len(entries)-len(missingIds)) + " / " + fmt.Sprint(len(entries)) + " devices!"
+		fmt.Fprintln(m.out, string(colorGreen), "\n\u2713", successMsg, string(colorReset))
+
+		if len(missingIds) > 0 {
+			fmt.Fprintf(m.out, "%sWarning: the following device IDs were not found - %s\n%s", string(colorYellow), strings.Join(missingIds, ", "), string(colorReset))
+		}
+	}
+
+	return result, nil
+}
+
+// collectDevices returns the devices selected by the device list, or every
+// device of the source when no list is given, along with the IDs of listed
+// devices missing from the source.
+func (m *Migrator) collectDevices(source DeviceSource) ([]*cbiotcore.Device, []*DeviceListEntry, []string, error) {
+	entries, err := m.deviceList()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if entries == nil {
+		devices, err := listAllDevices(source)
+		return devices, nil, nil, err
+	}
+
+	devicesC := make(chan *cbiotcore.Device)
+	var missingIds []string
+	go func() {
+		defer close(devicesC)
+		missingIds, err = source.GetByIds(getDeviceListIds(entries), devicesC)
+	}()
+
+	devices := make([]*cbiotcore.Device, 0, len(entries))
+	for device := range devicesC {
+		devices = append(devices, device)
+	}
+	return devices, entries, missingIds, err
+}
+
+// deviceList returns the devices selected by Options.DeviceIds or
+// Options.DevicesFile, or nil when every device of the source is selected.
+func (m *Migrator) deviceList() ([]*DeviceListEntry, error) {
+	if len(m.opts.DeviceIds) > 0 {
+		entries := make([]*DeviceListEntry, 0, len(m.opts.DeviceIds))
+		for _, id := range m.opts.DeviceIds {
+			entries = append(entries, &DeviceListEntry{Id: id})
+		}
+		return entries, nil
+	}
+
+	if m.opts.DevicesFile != "" {
+		entries, err := readDeviceList(m.opts.DevicesFile)
+		return entries, markError(ErrInvalidOptions, err)
+	}
+	return nil, nil
+}
+
+func listAllDevices(source DeviceSource) ([]*cbiotcore.Device, error) {
+	devicesC := make(chan *cbiotcore.Device)
+	errC := make(chan error, 1)
+	go func() {
+		defer close(devicesC)
+		errC <- source.List(devicesC)
+	}()
+
+	devices := make([]*cbiotcore.Device, 0)
+	for device := range devicesC {
+		devices = append(devices, device)
+	}
+	return devices, <-errC
+}
@@ -0,0 +1,119 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// archiveSource reads devices from an archive created by the export command.
+type archiveSource struct {
+	archive *ArchiveReader
+
+	indexOnce sync.Once
+	indexErr  error
+	configs   map[string]*cbiotcore.DeviceConfig
+	bindings  map[string][]string
+}
+
+func newArchiveSource(out io.Writer, dir string) (*archiveSource, error) {
+	archive, err := OpenArchive(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open archive: %w", err)
+	}
+
+	manifest := archive.Manifest
+	fmt.Fprintf(out, "Reading %d devices exported from %s/%s/%s at %s by %s\n", manifest.DeviceCount, manifest.Project, manifest.Region, manifest.Registry, manifest.CreatedAt, manifest.ToolVersion)
+
+	return &archiveSource{archive: archive}, nil
+}
+
+func (s *archiveSource) Count() (int, error) {
+	return s.archive.Manifest.DeviceCount, nil
+}
+
+func (s *archiveSource) List(devicesC chan<- *cbiotcore.Device) error {
+	return s.archive.ReadDevices(func(archiveDevice *ArchiveDevice) error {
+		devicesC <- archiveDevice.Device
+		return nil
+	})
+}
+
+func (s *archiveSource) GetByIds(deviceIds []string, devicesC chan<- *cbiotcore.Device) ([]string, error) {
+	found := make(map[string]bool, len(deviceIds))
+	for _, id := range deviceIds {
+		found[id] = false
+	}
+
+	err := s.archive.ReadDevices(func(archiveDevice *ArchiveDevice) error {
+		if _, ok := found[archiveDevice.Device.Id]; ok {
+			found[archiveDevice.Device.Id] = true
+			devicesC <- archiveDevice.Device
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	missingIds := make([]string, 0)
+	for _, id := range deviceIds {
+		if !found[id] {
+			missingIds = append(missingIds, id)
+		}
+	}
+	return missingIds, nil
+}
+
+// RegistryCredentials returns the CA certificates of the exported registry,
+// which are missing when the export could not fetch the registry details.
+func (s *archiveSource) RegistryCredentials() ([]*cbiotcore.RegistryCredential, error) {
+	if s.archive.Manifest.RegistryDetails == nil {
+		return nil, errors.New("the archive has no registry details")
+	}
+	return s.archive.Manifest.RegistryDetails.Credentials, nil
+}
+
+func (s *archiveSource) Credentials(device *cbiotcore.Device) ([]*cbiotcore.DeviceCredential, error) {
+	return device.Credentials, nil
+}
+
+func (s *archiveSource) Config(device *cbiotcore.Device) (*cbiotcore.DeviceConfig, error) {
+	if device.Config != nil {
+		return device.Config, nil
+	}
+
+	if err := s.index(); err != nil {
+		return nil, err
+	}
+	return s.configs[device.Id], nil
+}
+
+func (s *archiveSource) Bindings(device *cbiotcore.Device) ([]string, error) {
+	if err := s.index(); err != nil {
+		return nil, err
+	}
+	return s.bindings[device.Id], nil
+}
+
+// index loads the latest config version and the bindings of every device in a
+// single pass over the archive the first time either is needed.
+func (s *archiveSource) index() error {
+	s.indexOnce.Do(func() {
+		s.configs = make(map[string]*cbiotcore.DeviceConfig)
+		s.bindings = make(map[string][]string)
+		s.indexErr = s.archive.ReadDevices(func(archiveDevice *ArchiveDevice) error {
+			if len(archiveDevice.ConfigVersions) > 0 {
+				s.configs[archiveDevice.Device.Id] = archiveDevice.ConfigVersions[0]
+			}
+			if len(archiveDevice.BoundDevices) > 0 {
+				s.bindings[archiveDevice.Device.Id] = archiveDevice.BoundDevices
+			}
+			return nil
+		})
+	})
+	return s.indexErr
+}
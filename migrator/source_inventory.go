@@ -0,0 +1,204 @@
+package migrator
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+const inventoryMetadataPrefix = "metadata."
+
+// inventoryDevice is a device as described by an inventory file. JSON
+// inventories use the ClearBlade IoT Core device layout plus an optional list
+// of bound device IDs for gateways.
+type inventoryDevice struct {
+	cbiotcore.Device
+	BoundDevices []string `json:"boundDevices,omitempty"`
+}
+
+// inventorySource reads devices from a CSV, JSON array or NDJSON inventory
+// file, for devices that do not come from ClearBlade IoT Core.
+type inventorySource struct {
+	devices []*inventoryDevice
+	byId    map[string]*inventoryDevice
+}
+
+func newInventorySource(path string) (*inventorySource, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory: %w", err)
+	}
+
+	var devices []*inventoryDevice
+	switch deviceListFormat(path, content) {
+	case "json":
+		devices, err = parseJSONInventory(content)
+	case "ndjson":
+		devices, err = parseNDJSONInventory(content)
+	default:
+		devices, err = parseCSVInventory(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse inventory %s: %w", path, err)
+	}
+
+	byId := make(map[string]*inventoryDevice, len(devices))
+	for _, device := range devices {
+		byId[device.Id] = device
+	}
+
+	return &inventorySource{devices: devices, byId: byId}, nil
+}
+
+func (s *inventorySource) Count() (int, error) {
+	return len(s.devices), nil
+}
+
+func (s *inventorySource) List(devicesC chan<- *cbiotcore.Device) error {
+	for _, device := range s.devices {
+		devicesC <- &device.Device
+	}
+	return nil
+}
+
+func (s *inventorySource) GetByIds(deviceIds []string, devicesC chan<- *cbiotcore.Device) ([]string, error) {
+	missingIds := make([]string, 0)
+	for _, id := range deviceIds {
+		device, ok := s.byId[id]
+		if !ok {
+			missingIds = append(missingIds, id)
+			continue
+		}
+		devicesC <- &device.Device
+	}
+	return missingIds, nil
+}
+
+func (s *inventorySource) Credentials(device *cbiotcore.Device) ([]*cbiotcore.DeviceCredential, error) {
+	return device.Credentials, nil
+}
+
+func (s *inventorySource) Config(device *cbiotcore.Device) (*cbiotcore.DeviceConfig, error) {
+	return device.Config, nil
+}
+
+func (s *inventorySource) Bindings(device *cbiotcore.Device) ([]string, error) {
+	if inventoryDevice, ok := s.byId[device.Id]; ok {
+		return inventoryDevice.BoundDevices, nil
+	}
+	return nil, nil
+}
+
+func parseJSONInventory(content []byte) ([]*inventoryDevice, error) {
+	var devices []*inventoryDevice
+	if err := json.Unmarshal(content, &devices); err != nil {
+		return nil, err
+	}
+
+	for i, device := range devices {
+		if device == nil || device.Id == "" {
+			return nil, fmt.Errorf("item %d: missing \"id\" field", i)
+		}
+	}
+	return devices, nil
+}
+
+func parseNDJSONInventory(content []byte) ([]*inventoryDevice, error) {
+	devices := make([]*inventoryDevice, 0)
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for decoder.More() {
+		var device inventoryDevice
+		if err := decoder.Decode(&device); err != nil {
+			return nil, fmt.Errorf("item %d: %w", len(devices), err)
+		}
+		if device.Id == "" {
+			return nil, fmt.Errorf("item %d: missing \"id\" field", len(devices))
+		}
+		devices = append(devices, &device)
+	}
+	return devices, nil
+}
+
+// parseCSVInventory reads an inventory CSV file. The first row must be a header
+// with an id column. The blocked, key_format, key, expiration_time, gateway and
+// bound_devices columns are optional, and columns prefixed with "metadata."
+// become device metadata.
+func parseCSVInventory(content []byte) ([]*inventoryDevice, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 || !hasDeviceListHeader(records[0]) {
+		return nil, fmt.Errorf("inventory CSV files must start with a header row containing an id column")
+	}
+
+	header := records[0]
+	devices := make([]*inventoryDevice, 0, len(records)-1)
+	for lineNum, line := range records[1:] {
+		device := &inventoryDevice{}
+		var credential *cbiotcore.DeviceCredential
+
+		for i, value := range line {
+			column := strings.ToLower(strings.TrimSpace(header[i]))
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+
+			switch {
+			case isDeviceIdColumn(column):
+				device.Id = value
+			case column == "blocked":
+				device.Blocked, err = strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid blocked value %q", lineNum+2, value)
+				}
+			case column == "key_format" || column == "key" || column == "expiration_time":
+				if credential == nil {
+					credential = &cbiotcore.DeviceCredential{PublicKey: &cbiotcore.PublicKeyCredential{}}
+				}
+				switch column {
+				case "key_format":
+					credential.PublicKey.Format = value
+				case "key":
+					credential.PublicKey.Key = value
+				default:
+					credential.ExpirationTime = value
+				}
+			case column == "gateway":
+				isGateway, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid gateway value %q", lineNum+2, value)
+				}
+				if isGateway {
+					device.GatewayConfig = &cbiotcore.GatewayConfig{GatewayType: "GATEWAY"}
+				}
+			case column == "bound_devices":
+				device.BoundDevices = strings.Split(value, ";")
+			case strings.HasPrefix(column, inventoryMetadataPrefix):
+				if device.Metadata == nil {
+					device.Metadata = make(map[string]string)
+				}
+				device.Metadata[strings.TrimSpace(header[i])[len(inventoryMetadataPrefix):]] = value
+			}
+		}
+
+		if device.Id == "" {
+			return nil, fmt.Errorf("line %d: missing device id", lineNum+2)
+		}
+
+		if credential != nil {
+			device.Credentials = []*cbiotcore.DeviceCredential{credential}
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
@@ -0,0 +1,284 @@
+package migrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// deviceBatch holds the outcome of a single DeviceIds(...) list call. Batches
+// are fetched concurrently but reported in the order they appear in the CSV.
+type deviceBatch struct {
+	deviceIds  []string
+	devices    []*cbiotcore.Device
+	missingIds []string
+	err        error
+}
+
+// iotCoreSource reads devices from a ClearBlade IoT Core registry.
+type iotCoreSource struct {
+	service      *cbiotcore.Service
+	devices      *cbiotcore.ProjectsLocationsRegistriesDevicesService
+	regDetails   *cbiotcore.RegistryUserCredentials
+	registryPath string
+	pageSize     int
+	fetchWorkers int
+	out          io.Writer
+}
+
+func (m *Migrator) newIotCoreSource() *iotCoreSource {
+	return &iotCoreSource{
+		service:      m.service,
+		devices:      cbiotcore.NewProjectsLocationsRegistriesDevicesService(m.service),
+		regDetails:   m.regDetails,
+		registryPath: m.registryPath,
+		pageSize:     m.opts.PageSize,
+		fetchWorkers: m.opts.FetchWorkers,
+		out:          m.out,
+	}
+}
+
+func (s *iotCoreSource) Count() (int, error) {
+	return getDeviceCount(s.regDetails)
+}
+
+func (s *iotCoreSource) List(devicesC chan<- *cbiotcore.Device) error {
+	devices, err := s.fetchAllDevices()
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		devicesC <- device
+	}
+	return nil
+}
+
+func (s *iotCoreSource) GetByIds(deviceIds []string, devicesC chan<- *cbiotcore.Device) ([]string, error) {
+	missingIds := make([]string, 0)
+	for _, batch := range s.fetchDevicesFromCSV(deviceIds, devicesC) {
+		if batch.err != nil {
+			return missingIds, fmt.Errorf("error fetching device list: %w", batch.err)
+		}
+		missingIds = append(missingIds, batch.missingIds...)
+	}
+	return missingIds, nil
+}
+
+func (s *iotCoreSource) RegistryCredentials() ([]*cbiotcore.RegistryCredential, error) {
+	registry, err := cbiotcore.NewProjectsLocationsRegistriesService(s.service).Get(s.registryPath).Do()
+	if err != nil {
+		return nil, err
+	}
+	return registry.Credentials, nil
+}
+
+func (s *iotCoreSource) Credentials(device *cbiotcore.Device) ([]*cbiotcore.DeviceCredential, error) {
+	return device.Credentials, nil
+}
+
+func (s *iotCoreSource) Config(device *cbiotcore.Device) (*cbiotcore.DeviceConfig, error) {
+	if device.Config != nil {
+		return device.Config, nil
+	}
+
+	resp, err := cbiotcore.NewProjectsLocationsRegistriesDevicesConfigVersionsService(s.service).List(s.devicePath(device.Id)).NumVersions(1).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.DeviceConfigs) == 0 {
+		return nil, nil
+	}
+	return resp.DeviceConfigs[0], nil
+}
+
+func (s *iotCoreSource) Bindings(device *cbiotcore.Device) ([]string, error) {
+	if !isGateway(device) {
+		return nil, nil
+	}
+	return s.fetchBoundDeviceIds(device.Id)
+}
+
+func (s *iotCoreSource) devicePath(deviceId string) string {
+	return fmt.Sprintf("%s/devices/%s", s.registryPath, deviceId)
+}
+
+func getDeviceCount(creds *cbiotcore.RegistryUserCredentials) (int, error) {
+	url := fmt.Sprintf("%s/api/v/1/code/%s/getNumDevicesGateways", creds.Url, creds.SystemKey)
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Close = true
+	req.Header.Add("ClearBlade-UserToken", creds.Token)
+
+	client := http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("getDeviceCount HTTP Error %d: %s", resp.StatusCode, string(body))
+	}
+	var counts struct {
+		Counts struct {
+			Devices float64 `json:"devices"`
+		} `json:"counts"`
+	}
+	if err := json.Unmarshal(body, &counts); err != nil {
+		return 0, markError(ErrUnexpectedResponse, fmt.Errorf("getDeviceCount unexpected response: %w", err))
+	}
+	return int(counts.Counts.Devices), nil
+}
+
+// fetchDevicesFromCSV fetches the given device IDs in pageSize batches using up
+// to fetchWorkers concurrent list calls. Devices are sent to devicesC as soon as
+// their batch returns so migration can start before all batches are fetched.
+// The returned batches are in the same order as deviceIds, and failed batches
+// hold their error. Once a batch fails, no other batch is started and the
+// batches still being fetched do not send their devices, as the run fails.
+func (s *iotCoreSource) fetchDevicesFromCSV(deviceIds []string, devicesC chan<- *cbiotcore.Device) []*deviceBatch {
+	batches := splitDeviceIds(deviceIds, s.pageSize)
+	if len(batches) > 1 {
+		fmt.Fprintf(s.out, "\nMore than %d devices specified in the device list. Fetching %d batches using %d workers...\n", s.pageSize, len(batches), s.fetchWorkers)
+	}
+
+	sem := make(chan struct{}, max(s.fetchWorkers, 1))
+	var wg sync.WaitGroup
+
+	// failed is closed by the first failed batch
+	failed := make(chan struct{})
+	var failOnce sync.Once
+
+	for _, batch := range batches {
+		sem <- struct{}{}
+		select {
+		case <-failed:
+			<-sem
+			wg.Wait()
+			return batches
+		default:
+		}
+
+		wg.Add(1)
+		go func(batch *deviceBatch) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			batch.devices, batch.err = s.fetchDeviceList(batch.deviceIds)
+			if batch.err != nil {
+				failOnce.Do(func() { close(failed) })
+				return
+			}
+			batch.missingIds = getMissingDeviceIds(batch.devices, batch.deviceIds)
+
+			for _, device := range batch.devices {
+				select {
+				case <-failed:
+					return
+				case devicesC <- device:
+				}
+			}
+		}(batch)
+	}
+
+	wg.Wait()
+
+	return batches
+}
+
+func splitDeviceIds(deviceIds []string, pageSize int) []*deviceBatch {
+	if pageSize < 1 {
+		pageSize = 1
+	}
+
+	batches := make([]*deviceBatch, 0, len(deviceIds)/pageSize+1)
+	for start := 0; start < len(deviceIds); start += pageSize {
+		end := min(start+pageSize, len(deviceIds))
+		batches = append(batches, &deviceBatch{deviceIds: deviceIds[start:end]})
+	}
+	return batches
+}
+
+func (s *iotCoreSource) fetchAllDevices() ([]*cbiotcore.Device, error) {
+	var devices []*cbiotcore.Device
+
+	fmt.Fprintln(s.out)
+	spinner := getSpinner(s.out, "Fetching all devices from registry...")
+
+	req := s.devices.List(s.registryPath).PageSize(int64(s.pageSize))
+	for {
+		resp, err := req.Do()
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, resp.Devices...)
+
+		if resp.NextPageToken == "" {
+			return devices, nil
+		}
+
+		_ = spinner.Add(1)
+		req = req.PageToken(resp.NextPageToken)
+	}
+}
+
+func getMissingDeviceIds(devices []*cbiotcore.Device, deviceIds []string) []string {
+	missingDeviceIds := make([]string, 0)
+	for _, id := range deviceIds {
+		found := false
+		for _, device := range devices {
+			if device.Id == id {
+				found = true
+			}
+		}
+		if !found {
+			missingDeviceIds = append(missingDeviceIds, id)
+		}
+	}
+	return missingDeviceIds
+}
+
+func (s *iotCoreSource) fetchDeviceList(deviceIds []string) ([]*cbiotcore.Device, error) {
+	resp, err := s.devices.List(s.registryPath).DeviceIds(deviceIds...).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Devices, nil
+}
+
+func (s *iotCoreSource) fetchBoundDeviceIds(gatewayId string) ([]string, error) {
+	deviceIds := make([]string, 0)
+
+	req := s.devices.List(s.registryPath).GatewayListOptionsAssociationsGatewayId(gatewayId).PageSize(int64(s.pageSize))
+	for {
+		resp, err := req.Do()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, device := range resp.Devices {
+			deviceIds = append(deviceIds, device.Id)
+		}
+
+		if resp.NextPageToken == "" {
+			return deviceIds, nil
+		}
+		req = req.PageToken(resp.NextPageToken)
+	}
+}
@@ -0,0 +1,77 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+
+	cb "github.com/clearblade/Go-SDK"
+)
+
+// Target types supported by Options.TargetType.
+const (
+	TargetTypeEnterprise = "enterprise"
+	TargetTypeFile       = "file"
+)
+
+// DeviceTarget is where migrated devices are written to. Methods mirror the
+// ClearBlade Go-SDK developer calls the migration uses, scoped to a single
+// IoT Enterprise system.
+type DeviceTarget interface {
+	// GetDevice returns nil and no error when the device does not exist.
+	GetDevice(name string) (map[string]interface{}, error)
+	CreateDevice(name string, data map[string]interface{}) (map[string]interface{}, error)
+	UpdateDevice(name string, data map[string]interface{}) (map[string]interface{}, error)
+
+	// GetDevicePublicKeys returns the public keys of a device, in the
+	// {"public_key": "...", "key_format": 0} layout used by IoT Enterprise.
+	GetDevicePublicKeys(deviceName string) ([]map[string]interface{}, error)
+
+	// DeleteDevicePublicKeys removes every public key of a device.
+	DeleteDevicePublicKeys(deviceName string) error
+	AddDevicePublicKey(deviceName, publicKey, expirationTime string, keyFormat cb.KeyFormat) (map[string]interface{}, error)
+
+	// CreateRole returns a JSON object shaped like {"role_id": "..."}.
+	CreateRole(name string) (interface{}, error)
+	GetRole(name string) (map[string]interface{}, error)
+	AddTopicToRole(topic, roleId string, level int) error
+
+	// GetDeviceRoles returns the names of the roles of a device.
+	GetDeviceRoles(deviceName string) ([]string, error)
+	AddDeviceToRoles(deviceName string, roles []string) error
+
+	// Close flushes any buffered writes.
+	Close() error
+}
+
+// newDeviceTarget creates the target selected by Options.TargetType.
+func (m *Migrator) newDeviceTarget() (DeviceTarget, error) {
+	switch m.opts.TargetType {
+	case TargetTypeEnterprise:
+		if m.opts.EnterpriseUrl == "" || m.opts.EnterpriseMsgUrl == "" || m.opts.SystemKey == "" || m.opts.SystemSecret == "" || m.opts.DevEmail == "" || m.opts.DevPassword == "" {
+			return nil, markError(ErrInvalidOptions, errors.New("the IoT Enterprise URL, messaging URL, system key, system secret, developer e-mail and developer password are required for target type "+TargetTypeEnterprise))
+		}
+
+		devClient, err := m.authenticateCbEnterprise()
+		if err != nil {
+			return nil, err
+		}
+		return newEnterpriseTarget(devClient, m.opts.SystemKey), nil
+	case TargetTypeFile:
+		if m.opts.TargetPath == "" {
+			return nil, markError(ErrInvalidOptions, fmt.Errorf("a target path is required for target type %s", TargetTypeFile))
+		}
+
+		absPath, err := getAbsPath(m.opts.TargetPath)
+		if err != nil {
+			return nil, markError(ErrInvalidOptions, fmt.Errorf("cannot resolve target path: %w", err))
+		}
+
+		target, err := newFileTarget(absPath)
+		if err != nil {
+			return nil, markError(ErrInvalidOptions, fmt.Errorf("unable to open target file: %w", err))
+		}
+		return target, nil
+	default:
+		return nil, markError(ErrInvalidOptions, fmt.Errorf("unknown target type %q. Supported target types are %s and %s", m.opts.TargetType, TargetTypeEnterprise, TargetTypeFile))
+	}
+}
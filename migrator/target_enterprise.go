@@ -0,0 +1,168 @@
+package migrator
+
+import (
+	"fmt"
+	"net/http"
+
+	cb "github.com/clearblade/Go-SDK"
+)
+
+// enterpriseTarget writes devices to an IoT Enterprise system using the Go-SDK.
+// Failed calls are returned as an APIError when the Go-SDK error carries a
+// structured error body.
+type enterpriseTarget struct {
+	client    *cb.DevClient
+	systemKey string
+}
+
+func newEnterpriseTarget(client *cb.DevClient, systemKey string) *enterpriseTarget {
+	return &enterpriseTarget{client: client, systemKey: systemKey}
+}
+
+func (t *enterpriseTarget) GetDevice(name string) (map[string]interface{}, error) {
+	query := cb.NewQuery()
+	query.EqualTo("name", name)
+
+	devices, err := t.client.GetDevices(t.systemKey, query)
+	if err != nil {
+		return nil, newSDKError(err)
+	}
+	if len(devices) == 0 {
+		return nil, nil
+	}
+
+	device, ok := devices[0].(map[string]interface{})
+	if !ok {
+		return nil, markError(ErrUnexpectedResponse, fmt.Errorf("unexpected device %v", devices[0]))
+	}
+	return device, nil
+}
+
+func (t *enterpriseTarget) CreateDevice(name string, data map[string]interface{}) (map[string]interface{}, error) {
+	device, err := t.client.CreateDevice(t.systemKey, name, data)
+	return device, newSDKError(err)
+}
+
+func (t *enterpriseTarget) UpdateDevice(name string, data map[string]interface{}) (map[string]interface{}, error) {
+	device, err := t.client.UpdateDevice(t.systemKey, name, data)
+	return device, newSDKError(err)
+}
+
+func (t *enterpriseTarget) GetDevicePublicKeys(deviceName string) ([]map[string]interface{}, error) {
+	keys, err := t.client.GetDevicePublicKeys(t.systemKey, deviceName)
+	if err != nil {
+		return nil, newSDKError(err)
+	}
+
+	publicKeys := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		publicKey, ok := key.(map[string]interface{})
+		if !ok {
+			return nil, markError(ErrUnexpectedResponse, fmt.Errorf("unexpected public key %v", key))
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	return publicKeys, nil
+}
+
+func (t *enterpriseTarget) DeleteDevicePublicKeys(deviceName string) error {
+	delQuery := cb.NewQuery()
+	delQuery.GreaterThanEqualTo("key_format", 0)
+
+	_, err := t.client.DeleteDevicePublicKey(t.systemKey, deviceName, delQuery)
+	return newSDKError(err)
+}
+
+func (t *enterpriseTarget) AddDevicePublicKey(deviceName, publicKey, expirationTime string, keyFormat cb.KeyFormat) (map[string]interface{}, error) {
+	key, err := t.client.AddDevicePublicKey(t.systemKey, deviceName, publicKey, expirationTime, keyFormat)
+	return key, newSDKError(err)
+}
+
+func (t *enterpriseTarget) CreateRole(name string) (interface{}, error) {
+	role, err := t.client.CreateRole(t.systemKey, name)
+	return role, newSDKError(err)
+}
+
+func (t *enterpriseTarget) GetRole(name string) (map[string]interface{}, error) {
+	role, err := t.client.GetRole(t.systemKey, name)
+	if err != nil {
+		// The Go-SDK returns an empty role when no role has the name
+		if role != nil && len(role) == 0 {
+			return nil, &APIError{StatusCode: http.StatusNotFound, Message: err.Error()}
+		}
+		return nil, newSDKError(err)
+	}
+	return role, nil
+}
+
+func (t *enterpriseTarget) AddTopicToRole(topic, roleId string, level int) error {
+	return newSDKError(t.client.AddTopicToRole(t.systemKey, topic, roleId, level))
+}
+
+// AddCollectionToRole updates the role with the collection permission itself,
+// as the AddCollectionToRole call of the Go-SDK does not send a request.
+func (t *enterpriseTarget) AddCollectionToRole(collectionId, roleId string, level int) error {
+	role := map[string]interface{}{
+		"ID": roleId,
+		"Permissions": map[string]interface{}{
+			"collections": []map[string]interface{}{
+				{
+					"itemInfo":    map[string]interface{}{"id": collectionId},
+					"permissions": level,
+				},
+			},
+		},
+	}
+	return newSDKError(t.client.UpdateRole(t.systemKey, "", role))
+}
+
+func (t *enterpriseTarget) AddServiceToRole(service, roleId string, level int) error {
+	return newSDKError(t.client.AddServiceToRole(t.systemKey, service, roleId, level))
+}
+
+func (t *enterpriseTarget) AddDevicesToRole(roleId string, level int) error {
+	return newSDKError(t.client.AddGenericPermissionToRole(t.systemKey, roleId, "devices", level))
+}
+
+func (t *enterpriseTarget) GetDeviceRoles(deviceName string) ([]string, error) {
+	roles, err := t.client.GetDeviceRoles(t.systemKey, deviceName)
+	return roles, newSDKError(err)
+}
+
+func (t *enterpriseTarget) AddDeviceToRoles(deviceName string, roles []string) error {
+	return newSDKError(t.client.AddDeviceToRoles(t.systemKey, deviceName, roles))
+}
+
+func (t *enterpriseTarget) RemoveDeviceFromRoles(deviceName string, roles []string) error {
+	return newSDKError(t.client.UpdateDeviceRoles(t.systemKey, deviceName, nil, roles))
+}
+
+func (t *enterpriseTarget) DeleteRole(roleId string) error {
+	return newSDKError(t.client.DeleteRole(t.systemKey, roleId))
+}
+
+func (t *enterpriseTarget) GetCACertificates() ([]string, error) {
+	certificates, err := t.client.GetRootCACertificates(t.systemKey)
+	if err != nil {
+		return nil, newSDKError(err)
+	}
+
+	pems := make([]string, 0, len(certificates))
+	for _, certificate := range certificates {
+		if fields, ok := certificate.(map[string]interface{}); ok {
+			if pem, ok := fields["certificate"].(string); ok {
+				pems = append(pems, pem)
+			}
+		}
+	}
+	return pems, nil
+}
+
+func (t *enterpriseTarget) AddCACertificate(certificate string) error {
+	_, err := t.client.AddRootCACertificate(t.systemKey, certificate)
+	return newSDKError(err)
+}
+
+func (t *enterpriseTarget) Close() error {
+	return nil
+}
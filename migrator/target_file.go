@@ -0,0 +1,388 @@
+package migrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+
+	cb "github.com/clearblade/Go-SDK"
+)
+
+// FileTargetState is everything written to a file target. It is saved as JSON
+// so rehearsal runs can be inspected and diffed.
+type FileTargetState struct {
+	Devices     map[string]map[string]interface{} `json:"devices"`
+	DeviceKeys  map[string][]FileTargetKey        `json:"deviceKeys"`
+	Roles       map[string]*FileTargetRole        `json:"roles"`
+	DeviceRoles map[string][]string               `json:"deviceRoles"`
+
+	// CACertificates holds the PEM encoded CA certificates trusted for X.509
+	// device authentication.
+	CACertificates []string `json:"caCertificates,omitempty"`
+}
+
+// FileTargetKey is a device public key.
+type FileTargetKey struct {
+	PublicKey      string       `json:"publicKey"`
+	ExpirationTime string       `json:"expirationTime,omitempty"`
+	KeyFormat      cb.KeyFormat `json:"keyFormat"`
+}
+
+// FileTargetRole is a role and the permission level of each of its topics,
+// collections, services and of the devices table.
+type FileTargetRole struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Topics      map[string]int `json:"topics"`
+	Collections map[string]int `json:"collections,omitempty"`
+	Services    map[string]int `json:"services,omitempty"`
+	Devices     int            `json:"devices,omitempty"`
+}
+
+// fileTarget keeps migrated devices in memory and saves them to a JSON file on
+// Close. It returns the same API errors as IoT Enterprise so migrations
+// behave the same as against a live system. When path is empty nothing is
+// saved, which is useful for tests.
+type fileTarget struct {
+	path  string
+	mu    sync.Mutex
+	state *FileTargetState
+}
+
+func newFileTarget(path string) (*fileTarget, error) {
+	t := &fileTarget{
+		path: path,
+		state: &FileTargetState{
+			Devices:     make(map[string]map[string]interface{}),
+			DeviceKeys:  make(map[string][]FileTargetKey),
+			Roles:       make(map[string]*FileTargetRole),
+			DeviceRoles: make(map[string][]string),
+		},
+	}
+
+	if path == "" {
+		return t, nil
+	}
+
+	// Continue from an earlier rehearsal, the same way a rerun against a live
+	// system sees previously migrated devices
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, t.state); err != nil {
+		return nil, fmt.Errorf("invalid target file %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// State returns the current contents of the target.
+func (t *fileTarget) State() *FileTargetState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+func (t *fileTarget) GetDevice(name string) (map[string]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	device, ok := t.state.Devices[name]
+	if !ok {
+		return nil, nil
+	}
+	return copyDeviceData(device), nil
+}
+
+func (t *fileTarget) CreateDevice(name string, data map[string]interface{}) (map[string]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.state.Devices[name]; ok {
+		return nil, fileTargetError(http.StatusConflict, "Device with name '%s' already exists in system", name)
+	}
+
+	device := copyDeviceData(data)
+	t.state.Devices[name] = device
+	return device, nil
+}
+
+func (t *fileTarget) UpdateDevice(name string, data map[string]interface{}) (map[string]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	device, ok := t.state.Devices[name]
+	if !ok {
+		return nil, fileTargetError(http.StatusNotFound, "Device with name '%s' not found", name)
+	}
+
+	for column, value := range data {
+		device[column] = value
+	}
+	return copyDeviceData(device), nil
+}
+
+func (t *fileTarget) GetDevicePublicKeys(deviceName string) ([]map[string]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.state.Devices[deviceName]; !ok {
+		return nil, fileTargetError(http.StatusNotFound, "Device with name '%s' not found", deviceName)
+	}
+
+	keys := make([]map[string]interface{}, 0, len(t.state.DeviceKeys[deviceName]))
+	for _, key := range t.state.DeviceKeys[deviceName] {
+		keys = append(keys, fileTargetKeyData(key))
+	}
+	return keys, nil
+}
+
+func (t *fileTarget) DeleteDevicePublicKeys(deviceName string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.state.DeviceKeys, deviceName)
+	return nil
+}
+
+func (t *fileTarget) AddDevicePublicKey(deviceName, publicKey, expirationTime string, keyFormat cb.KeyFormat) (map[string]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.state.Devices[deviceName]; !ok {
+		return nil, fileTargetError(http.StatusNotFound, "Device with name '%s' not found", deviceName)
+	}
+
+	key := FileTargetKey{
+		PublicKey:      publicKey,
+		ExpirationTime: expirationTime,
+		KeyFormat:      keyFormat,
+	}
+	t.state.DeviceKeys[deviceName] = append(t.state.DeviceKeys[deviceName], key)
+	return fileTargetKeyData(key), nil
+}
+
+func (t *fileTarget) CreateRole(name string) (interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.state.Roles[name]; ok {
+		return nil, fileTargetError(http.StatusBadRequest, duplicateRoleMessage)
+	}
+
+	role := &FileTargetRole{ID: name, Name: name, Topics: make(map[string]int)}
+	t.state.Roles[name] = role
+	return map[string]interface{}{"role_id": role.ID}, nil
+}
+
+func (t *fileTarget) GetRole(name string) (map[string]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	role, ok := t.state.Roles[name]
+	if !ok {
+		return nil, fileTargetError(http.StatusNotFound, "No role found with name: '%s'", name)
+	}
+	return role.apiRole(), nil
+}
+
+// apiRole returns the role the way the IoT Enterprise roles endpoint does once
+// decoded from JSON, with its permissions listed by kind.
+func (r *FileTargetRole) apiRole() map[string]interface{} {
+	topics := make([]interface{}, 0, len(r.Topics))
+	for topic, level := range r.Topics {
+		topics = append(topics, map[string]interface{}{"Name": topic, "Level": float64(level)})
+	}
+	collections := make([]interface{}, 0, len(r.Collections))
+	for collection, level := range r.Collections {
+		collections = append(collections, map[string]interface{}{"ID": collection, "Level": float64(level)})
+	}
+	services := make([]interface{}, 0, len(r.Services))
+	for service, level := range r.Services {
+		services = append(services, map[string]interface{}{"Name": service, "Level": float64(level)})
+	}
+
+	return map[string]interface{}{
+		"ID":   r.ID,
+		"Name": r.Name,
+		"Permissions": map[string]interface{}{
+			"Topics":      topics,
+			"Collections": collections,
+			"Services":    services,
+			"DevicesList": map[string]interface{}{"Name": "devices", "Level": float64(r.Devices)},
+		},
+	}
+}
+
+func (t *fileTarget) AddTopicToRole(topic, roleId string, level int) error {
+	return t.updateRole(roleId, func(role *FileTargetRole) {
+		role.Topics[topic] = level
+	})
+}
+
+func (t *fileTarget) AddCollectionToRole(collectionId, roleId string, level int) error {
+	return t.updateRole(roleId, func(role *FileTargetRole) {
+		if role.Collections == nil {
+			role.Collections = make(map[string]int)
+		}
+		role.Collections[collectionId] = level
+	})
+}
+
+func (t *fileTarget) AddServiceToRole(service, roleId string, level int) error {
+	return t.updateRole(roleId, func(role *FileTargetRole) {
+		if role.Services == nil {
+			role.Services = make(map[string]int)
+		}
+		role.Services[service] = level
+	})
+}
+
+func (t *fileTarget) AddDevicesToRole(roleId string, level int) error {
+	return t.updateRole(roleId, func(role *FileTargetRole) {
+		role.Devices = level
+	})
+}
+
+// updateRole applies a change to the role with the given ID.
+func (t *fileTarget) updateRole(roleId string, change func(role *FileTargetRole)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, role := range t.state.Roles {
+		if role.ID == roleId {
+			change(role)
+			return nil
+		}
+	}
+	return fileTargetError(http.StatusNotFound, "Role with id '%s' not found", roleId)
+}
+
+func (t *fileTarget) GetDeviceRoles(deviceName string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.state.Devices[deviceName]; !ok {
+		return nil, fileTargetError(http.StatusNotFound, "Device with name '%s' not found", deviceName)
+	}
+	return append([]string{}, t.state.DeviceRoles[deviceName]...), nil
+}
+
+func (t *fileTarget) AddDeviceToRoles(deviceName string, roles []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.state.Devices[deviceName]; !ok {
+		return fileTargetError(http.StatusNotFound, "Device with name '%s' not found", deviceName)
+	}
+
+	for _, roleName := range roles {
+		if _, ok := t.state.Roles[roleName]; !ok {
+			return fileTargetError(http.StatusNotFound, "Role with name '%s' not found", roleName)
+		}
+		for _, existing := range t.state.DeviceRoles[deviceName] {
+			if existing == roleName {
+				return fileTargetError(http.StatusConflict, "Device '%s' already has role '%s'", deviceName, roleName)
+			}
+		}
+		t.state.DeviceRoles[deviceName] = append(t.state.DeviceRoles[deviceName], roleName)
+	}
+	return nil
+}
+
+func (t *fileTarget) RemoveDeviceFromRoles(deviceName string, roles []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.state.Devices[deviceName]; !ok {
+		return fileTargetError(http.StatusNotFound, "Device with name '%s' not found", deviceName)
+	}
+	t.state.DeviceRoles[deviceName] = slices.DeleteFunc(t.state.DeviceRoles[deviceName], func(roleName string) bool {
+		return slices.Contains(roles, roleName)
+	})
+	return nil
+}
+
+// DeleteRole deletes a role and takes it away from every device.
+func (t *fileTarget) DeleteRole(roleId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, role := range t.state.Roles {
+		if role.ID != roleId {
+			continue
+		}
+		delete(t.state.Roles, name)
+		for deviceName, roles := range t.state.DeviceRoles {
+			t.state.DeviceRoles[deviceName] = slices.DeleteFunc(roles, func(roleName string) bool {
+				return roleName == name
+			})
+		}
+		return nil
+	}
+	return fileTargetError(http.StatusNotFound, "Role with id '%s' not found", roleId)
+}
+
+func (t *fileTarget) GetCACertificates() ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.state.CACertificates...), nil
+}
+
+func (t *fileTarget) AddCACertificate(certificate string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := parseCertificatePEM(certificate); err != nil {
+		return fileTargetError(http.StatusBadRequest, "Invalid certificate: %v", err)
+	}
+	t.state.CACertificates = append(t.state.CACertificates, certificate)
+	return nil
+}
+
+func (t *fileTarget) Close() error {
+	if t.path == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	content, err := json.MarshalIndent(t.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, content, 0644)
+}
+
+// fileTargetError returns the API error IoT Enterprise answers with in the same
+// situation.
+func fileTargetError(status int, format string, a ...interface{}) error {
+	return &APIError{StatusCode: status, Code: status, Message: fmt.Sprintf(format, a...)}
+}
+
+func copyDeviceData(data map[string]interface{}) map[string]interface{} {
+	device := make(map[string]interface{}, len(data))
+	for column, value := range data {
+		device[column] = value
+	}
+	return device
+}
+
+func fileTargetKeyData(key FileTargetKey) map[string]interface{} {
+	data := map[string]interface{}{
+		"public_key": key.PublicKey,
+		"key_format": int(key.KeyFormat),
+	}
+	if key.ExpirationTime != "" {
+		data["expiration_time"] = key.ExpirationTime
+	}
+	return data
+}
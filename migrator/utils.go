@@ -0,0 +1,187 @@
+package migrator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	cbiotcore "github.com/clearblade/go-iot"
+	"github.com/k0kubun/go-ansi"
+	"github.com/schollz/progressbar/v3"
+)
+
+var (
+	colorCyan   = "\033[36m"
+	colorReset  = "\033[0m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorRed    = "\033[31m"
+)
+
+func init() {
+	if runtime.GOOS == "windows" {
+		colorCyan = ""
+		colorReset = ""
+		colorGreen = ""
+		colorYellow = ""
+		colorRed = ""
+	}
+}
+
+func readCsvFile(filePath string) ([][]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read input file: %w", err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s as CSV: %w", filePath, err)
+	}
+
+	return records, nil
+}
+
+func getCBProjectID(filePath string) (string, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("error when opening service account file: %w", err)
+	}
+
+	var payload CBConfig
+	err = json.Unmarshal(content, &payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid service account file %s: %w", filePath, err)
+	}
+
+	return payload.Project, nil
+}
+
+func getProgressBar(out io.Writer, total int, description string) *progressbar.ProgressBar {
+	description = string(colorYellow) + description + string(colorReset)
+	bar := progressbar.NewOptions(total,
+		progressbar.OptionSetWriter(progressWriter(out)),
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionSetWidth(30),
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[green]=[reset]",
+			SaucerHead:    "[green]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}))
+
+	return bar
+}
+
+func getSpinner(out io.Writer, description string) *progressbar.ProgressBar {
+	description = string(colorYellow) + description + string(colorReset)
+	bar := progressbar.NewOptions(-1,
+		progressbar.OptionSetWriter(progressWriter(out)),
+		progressbar.OptionSetWidth(30),
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionShowCount(),
+	)
+	return bar
+}
+
+// progressWriter wraps stdout so progress bars render on Windows consoles.
+func progressWriter(out io.Writer) io.Writer {
+	if out == os.Stdout {
+		return ansi.NewAnsiStdout()
+	}
+	return out
+}
+
+func getAbsPath(path string) (string, error) {
+	if len(path) == 0 {
+		return path, nil
+	}
+
+	if path[0] != '~' {
+		return strings.TrimSuffix(path, "\r"), nil
+	}
+
+	if len(path) > 1 && path[1] != '/' && path[1] != '\\' {
+		return "", errors.New("cannot expand user-specific home dir")
+	}
+
+	usr, _ := user.Current()
+	dir := usr.HomeDir
+
+	return filepath.Join(dir, path[1:]), nil
+}
+
+// columnMapping maps a ClearBlade IoT Core device attribute to an IoT
+// Enterprise device column.
+type columnMapping struct {
+	attribute string
+	column    string
+}
+
+// readColumnMap reads a column mapping CSV file, with the IoT Core device
+// attribute in the first column and the IoT Enterprise column in the second.
+func readColumnMap(csvFile string) ([]columnMapping, error) {
+	absPath, err := getAbsPath(csvFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve column mapping CSV filepath: %w", err)
+	}
+
+	records, err := readCsvFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceType := reflect.TypeOf(cbiotcore.Device{})
+	mappings := make([]columnMapping, 0, len(records))
+	for lineNum, line := range records {
+		if len(line) < 2 {
+			return nil, fmt.Errorf("column mapping line %d: expected a device attribute and a column", lineNum+1)
+		}
+		if _, ok := deviceType.FieldByName(line[0]); !ok {
+			return nil, fmt.Errorf("column mapping line %d: unknown ClearBlade IoT Core device attribute %q", lineNum+1, line[0])
+		}
+		mappings = append(mappings, columnMapping{attribute: line[0], column: line[1]})
+	}
+	return mappings, nil
+}
+
+func transform(device *cbiotcore.Device, deviceType string, auth deviceAuth, columns []columnMapping, overrides map[string]interface{}) map[string]interface{} {
+	cbDevice := map[string]interface{}{
+		"name":                   device.Id,
+		"enabled":                !device.Blocked,
+		"type":                   deviceType,
+		"allow_key_auth":         auth.allowKeyAuth,
+		"allow_certificate_auth": auth.allowCertificateAuth,
+	}
+
+	for _, mapping := range columns {
+		cbDevice[mapping.column] = reflect.ValueOf(device).Elem().FieldByName(mapping.attribute).Interface()
+	}
+
+	// Values from the device list take precedence over everything else
+	for column, value := range overrides {
+		cbDevice[column] = value
+	}
+
+	return cbDevice
+}
+
+func getTimeString(timestamp time.Time) string {
+	if timestamp.Unix() == 0 {
+		return ""
+	}
+	return timestamp.Format(time.RFC3339)
+}
@@ -0,0 +1,171 @@
+package migrator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	cbiotcore "github.com/clearblade/go-iot"
+)
+
+// VerifyReport lists the differences found between the source and the target.
+type VerifyReport struct {
+	// Verified is the number of devices found in the target without any
+	// mismatch.
+	Verified   int
+	Mismatches []Mismatch
+
+	// MissingIds lists the devices of the device list missing from the source.
+	MissingIds []string
+}
+
+// Mismatch is a device field whose value in the target differs from the value
+// a migration would write.
+type Mismatch struct {
+	DeviceId string
+	Field    string
+	Expected string
+	Actual   string
+}
+
+// Verify checks that every device selected by the options was migrated: the
+// device exists in the target with the columns a migration would write and,
+// when the options migrate them, the same public keys and the device role.
+func (m *Migrator) Verify() (*VerifyReport, error) {
+	source, err := m.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := m.Target(); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.columnMapping(); err != nil {
+		return nil, err
+	}
+	if _, err := m.deviceAuthRules(); err != nil {
+		return nil, err
+	}
+
+	devices, entries, missingIds, err := m.collectDevices(source)
+	if err != nil {
+		return nil, markError(ErrSource, fmt.Errorf("error fetching devices: %w", err))
+	}
+	overrides := getDeviceListOverrides(entries)
+
+	report := &VerifyReport{MissingIds: missingIds}
+	for _, device := range devices {
+		mismatches, err := m.verifyDevice(source, device, overrides[device.Id])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, mismatch := range mismatches {
+			m.log.Warn("Device differs from the source", "device", mismatch.DeviceId, "field", mismatch.Field, "expected", mismatch.Expected, "actual", mismatch.Actual)
+		}
+		if len(mismatches) == 0 {
+			report.Verified++
+		}
+		report.Mismatches = append(report.Mismatches, mismatches...)
+	}
+
+	return report, nil
+}
+
+func (m *Migrator) verifyDevice(source DeviceSource, device *cbiotcore.Device, overrides map[string]interface{}) ([]Mismatch, error) {
+	actual, err := m.target.GetDevice(device.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching device %s from target: %w", device.Id, err)
+	}
+	if actual == nil {
+		return []Mismatch{{DeviceId: device.Id, Field: "device", Expected: "present", Actual: "missing"}}, nil
+	}
+
+	credentials, err := source.Credentials(device)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching credentials of device %s: %w", device.Id, err)
+	}
+
+	mismatches := make([]Mismatch, 0)
+	auth := m.deviceAuth(device, len(credentials))
+	expected := transform(device, m.opts.DeviceType, auth, m.columns, overrides)
+
+	// Devices created with a generated active key allow key auth, while
+	// devices that already existed keep the configured value
+	if _, ok := overrides["allow_key_auth"]; auth.generateActiveKey && !ok && actual["allow_key_auth"] == true {
+		expected["allow_key_auth"] = true
+	}
+	for column, value := range expected {
+		if fmt.Sprint(value) != fmt.Sprint(actual[column]) {
+			mismatches = append(mismatches, Mismatch{
+				DeviceId: device.Id,
+				Field:    column,
+				Expected: fmt.Sprint(value),
+				Actual:   fmt.Sprint(actual[column]),
+			})
+		}
+	}
+
+	if m.opts.UpdatePublicKeys && len(credentials) > 0 {
+		keyMismatch, err := m.verifyDeviceKeys(device, credentials)
+		if err != nil {
+			return nil, err
+		}
+		if keyMismatch != nil {
+			mismatches = append(mismatches, *keyMismatch)
+		}
+	}
+
+	if !m.opts.CreateDeviceRole {
+		return mismatches, nil
+	}
+
+	roles, err := m.target.GetDeviceRoles(device.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching roles of device %s: %w", device.Id, err)
+	}
+	if roleName := m.deviceRoleName(device, overrides); !slices.Contains(roles, roleName) {
+		mismatches = append(mismatches, Mismatch{
+			DeviceId: device.Id,
+			Field:    "roles",
+			Expected: roleName,
+			Actual:   strings.Join(roles, ", "),
+		})
+	}
+
+	return mismatches, nil
+}
+
+// verifyDeviceKeys compares the public keys of a device in the target with the
+// credentials a migration uploads, and returns the mismatch if they differ.
+func (m *Migrator) verifyDeviceKeys(device *cbiotcore.Device, credentials []*cbiotcore.DeviceCredential) (*Mismatch, error) {
+	keys, err := m.target.GetDevicePublicKeys(device.Id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching public keys of device %s: %w", device.Id, err)
+	}
+
+	// Only the credentials a migration uploads are expected in the target
+	expectedKeys := make([]string, 0, len(credentials))
+	for _, check := range m.checkDeviceCredentials(device.Id, credentials) {
+		if check.Action == CredentialActionMigrate {
+			expectedKeys = append(expectedKeys, strings.TrimSpace(check.key.Key))
+		}
+	}
+	actualKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		actualKeys = append(actualKeys, strings.TrimSpace(fmt.Sprint(key["public_key"])))
+	}
+	slices.Sort(expectedKeys)
+	slices.Sort(actualKeys)
+
+	if slices.Equal(expectedKeys, actualKeys) {
+		return nil, nil
+	}
+	return &Mismatch{
+		DeviceId: device.Id,
+		Field:    "public_keys",
+		Expected: fmt.Sprint(len(expectedKeys), " keys"),
+		Actual:   fmt.Sprint(len(actualKeys), " keys"),
+	}, nil
+}
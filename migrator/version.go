@@ -0,0 +1,4 @@
+package migrator
+
+// Version is the version of the migration tool, recorded in export archives.
+const Version = "v1.6.0"
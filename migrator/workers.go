@@ -0,0 +1,240 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolClosed is returned by AddTask once the worker pool was closed.
+var ErrPoolClosed = errors.New("worker pool is closed")
+
+// Task is a unit of work run by a WorkerPool. ctx is the context the pool was
+// started with.
+type Task func(ctx context.Context) error
+
+type WorkerPool interface {
+	// Run starts the workers. Workers stop taking tasks once ctx is done,
+	// leaving the remaining queued tasks unrun.
+	Run(ctx context.Context)
+
+	// AddTask queues a task, blocking while the queue is full. It fails with
+	// ErrPoolClosed after Close, and with the context error once the context
+	// given to Run is done.
+	AddTask(task Task) error
+
+	// Close stops accepting tasks. Tasks already queued still run.
+	Close()
+
+	// Wait blocks until every worker exited, which happens once the pool is
+	// closed and its queue is drained, or once the context is done.
+	Wait()
+
+	// Errors returns the errors returned by tasks, and a *PanicError for every
+	// task that panicked.
+	Errors() []error
+
+	// Stats returns a snapshot of the pool metrics.
+	Stats() PoolStats
+}
+
+// PanicError is a panic recovered from a task.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v", e.Value)
+}
+
+// newPanicError wraps a value returned by recover with the stack of the
+// panicking goroutine.
+func newPanicError(value interface{}) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+// PoolStats is a snapshot of the metrics of a worker pool.
+type PoolStats struct {
+	// Queued is the number of tasks waiting for a worker and InFlight the
+	// number of tasks running.
+	Queued   int
+	InFlight int
+
+	// Completed is the number of finished tasks, including the Failed tasks
+	// that returned an error or panicked.
+	Completed int
+	Failed    int
+
+	Workers []WorkerStats
+}
+
+// WorkerStats holds the metrics of a single worker.
+type WorkerStats struct {
+	Completed int
+	Failed    int
+
+	// Busy is the time the worker spent running tasks.
+	Busy time.Duration
+}
+
+// Throughput returns the number of tasks the worker completed per second of
+// busy time.
+func (s WorkerStats) Throughput() float64 {
+	if s.Busy <= 0 {
+		return 0
+	}
+	return float64(s.Completed) / s.Busy.Seconds()
+}
+
+type workerPool struct {
+	maxWorkers  int
+	queuedTaskC chan Task
+
+	// mu guards ctx and closed, so no task is sent once the queue is closed
+	mu     sync.RWMutex
+	ctx    context.Context
+	closed bool
+	wg     sync.WaitGroup
+
+	inFlight atomic.Int64
+
+	statsMu sync.Mutex
+	workers []WorkerStats
+	errs    []error
+}
+
+// NewWorkerPool will create an instance of WorkerPool with maxWorkers workers
+// and room for queueSize tasks waiting for a worker.
+func NewWorkerPool(maxWorkers, queueSize int) WorkerPool {
+	wp := &workerPool{
+		maxWorkers:  maxWorkers,
+		queuedTaskC: make(chan Task, queueSize),
+		workers:     make([]WorkerStats, maxWorkers),
+	}
+
+	return wp
+}
+
+func (wp *workerPool) Run(ctx context.Context) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.ctx != nil {
+		return
+	}
+	wp.ctx = ctx
+	wp.run(ctx)
+}
+
+func (wp *workerPool) AddTask(task Task) error {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+
+	if wp.closed {
+		return ErrPoolClosed
+	}
+	if wp.ctx == nil {
+		return errors.New("worker pool is not running")
+	}
+
+	select {
+	case wp.queuedTaskC <- task:
+		return nil
+	case <-wp.ctx.Done():
+		return wp.ctx.Err()
+	}
+}
+
+func (wp *workerPool) Close() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if !wp.closed {
+		wp.closed = true
+		close(wp.queuedTaskC)
+	}
+}
+
+func (wp *workerPool) Wait() {
+	wp.wg.Wait()
+}
+
+func (wp *workerPool) Errors() []error {
+	wp.statsMu.Lock()
+	defer wp.statsMu.Unlock()
+	return append([]error{}, wp.errs...)
+}
+
+func (wp *workerPool) Stats() PoolStats {
+	wp.statsMu.Lock()
+	defer wp.statsMu.Unlock()
+
+	stats := PoolStats{
+		Queued:   len(wp.queuedTaskC),
+		InFlight: int(wp.inFlight.Load()),
+		Workers:  append([]WorkerStats{}, wp.workers...),
+	}
+	for _, worker := range wp.workers {
+		stats.Completed += worker.Completed
+		stats.Failed += worker.Failed
+	}
+	return stats
+}
+
+func (wp *workerPool) run(ctx context.Context) {
+	for w := 0; w < wp.maxWorkers; w++ {
+		wp.wg.Add(1)
+		go func(worker int) {
+			defer wp.wg.Done()
+			for {
+				// Stop before taking another task once the context is done
+				if ctx.Err() != nil {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case task, ok := <-wp.queuedTaskC:
+					if !ok {
+						return
+					}
+					wp.runTask(ctx, worker, task)
+				}
+			}
+		}(w)
+	}
+}
+
+// runTask runs a task and records its outcome. A panicking task fails on its
+// own instead of taking down the worker and the migration with it.
+func (wp *workerPool) runTask(ctx context.Context, worker int, task Task) {
+	wp.inFlight.Add(1)
+	start := time.Now()
+
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(r)
+		}
+		wp.inFlight.Add(-1)
+
+		wp.statsMu.Lock()
+		defer wp.statsMu.Unlock()
+
+		stats := &wp.workers[worker]
+		stats.Completed++
+		stats.Busy += time.Since(start)
+		if err != nil {
+			stats.Failed++
+			wp.errs = append(wp.errs, err)
+		}
+	}()
+
+	err = task(ctx)
+}
@@ -0,0 +1,203 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolRunsEveryTask(t *testing.T) {
+	wp := NewWorkerPool(4, 2)
+	wp.Run(context.Background())
+
+	var ran atomic.Int64
+	for i := 0; i < 50; i++ {
+		if err := wp.AddTask(func(ctx context.Context) error {
+			ran.Add(1)
+			return nil
+		}); err != nil {
+			t.Fatalf("AddTask: %v", err)
+		}
+	}
+	wp.Close()
+	wp.Wait()
+
+	if ran.Load() != 50 {
+		t.Fatalf("ran %d tasks, want 50", ran.Load())
+	}
+
+	stats := wp.Stats()
+	if stats.Completed != 50 || stats.Failed != 0 || stats.Queued != 0 || stats.InFlight != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if len(stats.Workers) != 4 {
+		t.Fatalf("got stats for %d workers, want 4", len(stats.Workers))
+	}
+	completed := 0
+	for _, worker := range stats.Workers {
+		completed += worker.Completed
+	}
+	if completed != 50 {
+		t.Fatalf("workers completed %d tasks, want 50", completed)
+	}
+	if len(wp.Errors()) != 0 {
+		t.Fatalf("unexpected errors: %v", wp.Errors())
+	}
+}
+
+func TestWorkerPoolCollectsErrorsAndPanics(t *testing.T) {
+	wp := NewWorkerPool(2, 4)
+	wp.Run(context.Background())
+
+	taskErr := errors.New("task failed")
+	tasks := []Task{
+		func(ctx context.Context) error { return taskErr },
+		func(ctx context.Context) error { panic("boom") },
+		func(ctx context.Context) error { return nil },
+	}
+	for _, task := range tasks {
+		if err := wp.AddTask(task); err != nil {
+			t.Fatalf("AddTask: %v", err)
+		}
+	}
+	wp.Close()
+	wp.Wait()
+
+	errs := wp.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("got %d errors, want 2: %v", len(errs), errs)
+	}
+
+	var panicErr *PanicError
+	var sawTaskErr, sawPanic bool
+	for _, err := range errs {
+		if errors.Is(err, taskErr) {
+			sawTaskErr = true
+		}
+		if errors.As(err, &panicErr) {
+			sawPanic = true
+		}
+	}
+	if !sawTaskErr {
+		t.Fatalf("task error not collected: %v", errs)
+	}
+	if !sawPanic || panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Fatalf("panic not collected as a *PanicError: %v", errs)
+	}
+
+	stats := wp.Stats()
+	if stats.Completed != 3 || stats.Failed != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestWorkerPoolAddTaskAfterClose(t *testing.T) {
+	wp := NewWorkerPool(1, 1)
+	wp.Run(context.Background())
+	wp.Close()
+	wp.Wait()
+
+	err := wp.AddTask(func(ctx context.Context) error { return nil })
+	if !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("AddTask after Close returned %v, want ErrPoolClosed", err)
+	}
+}
+
+func TestWorkerPoolBoundedQueue(t *testing.T) {
+	wp := NewWorkerPool(1, 2)
+	wp.Run(context.Background())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	if err := wp.AddTask(func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+	<-started
+
+	for i := 0; i < 2; i++ {
+		if err := wp.AddTask(func(ctx context.Context) error { return nil }); err != nil {
+			t.Fatalf("AddTask: %v", err)
+		}
+	}
+
+	stats := wp.Stats()
+	if stats.Queued != 2 || stats.InFlight != 1 {
+		t.Fatalf("unexpected stats while the worker is busy: %+v", stats)
+	}
+
+	// The queue is full, so the next task waits for the worker
+	added := make(chan error)
+	go func() {
+		added <- wp.AddTask(func(ctx context.Context) error { return nil })
+	}()
+	select {
+	case err := <-added:
+		t.Fatalf("AddTask did not block on a full queue: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-added; err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+	wp.Close()
+	wp.Wait()
+
+	if stats := wp.Stats(); stats.Completed != 4 {
+		t.Fatalf("completed %d tasks, want 4", stats.Completed)
+	}
+}
+
+func TestWorkerPoolCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wp := NewWorkerPool(1, 1)
+	wp.Run(ctx)
+
+	started := make(chan struct{})
+	if err := wp.AddTask(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}); err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+	<-started
+
+	var ran atomic.Bool
+	if err := wp.AddTask(func(ctx context.Context) error {
+		ran.Store(true)
+		return nil
+	}); err != nil {
+		t.Fatalf("AddTask: %v", err)
+	}
+
+	cancel()
+
+	// Wait returns without Close once the context is done
+	done := make(chan struct{})
+	go func() {
+		wp.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after the context was cancelled")
+	}
+
+	if ran.Load() {
+		t.Fatal("queued task ran after the context was cancelled")
+	}
+	if err := wp.AddTask(func(ctx context.Context) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("AddTask after cancel returned %v, want context.Canceled", err)
+	}
+	if errs := wp.Errors(); len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
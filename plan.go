@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"clearblade-iot-enterprise-migration/migrator"
+)
+
+func runPlan(arguments []string) int {
+	args := &cliArgs{}
+	fs := newFlagSet("plan")
+	initMigrationFlags(fs, args)
+	_ = fs.Parse(arguments)
+	defer args.closeLog()
+
+	m, err := connectMigrator(args)
+	if err != nil {
+		return printError(err)
+	}
+	defer m.Close()
+
+	plan, err := m.Plan()
+	if err != nil {
+		return printError(err)
+	}
+
+	printPlan(plan)
+	return exitSuccess
+}
+
+func printPlan(plan *migrator.Plan) {
+	fmt.Println(string(colorCyan), "\n\n================= Migration Plan =================\n", string(colorReset))
+
+	for _, device := range plan.Devices {
+		details := []string{fmt.Sprint(device.Credentials, " credentials")}
+		if device.Gateway {
+			details = append(details, "gateway")
+		}
+		fmt.Printf("  %-6s %s (%s)\n", device.Action, device.Id, strings.Join(details, ", "))
+		for _, invalid := range device.InvalidCredentials {
+			fmt.Printf("%s         skipped %s\n%s", string(colorYellow), invalid, string(colorReset))
+		}
+	}
+
+	keyless := 0
+	for _, device := range plan.Devices {
+		if device.Credentials == 0 {
+			keyless++
+		}
+	}
+
+	creates := plan.Creates()
+	fmt.Println(string(colorGreen), "\n\u2713", len(plan.Devices), "devices:", creates, "to create,", len(plan.Devices)-creates, "to update", string(colorReset))
+
+	if keyless > 0 {
+		fmt.Printf("%sNote: %d devices have no credentials and can only connect with an active key\n%s", string(colorYellow), keyless, string(colorReset))
+	}
+	if len(plan.MissingIds) > 0 {
+		fmt.Printf("%sWarning: the following device IDs were not found - %s\n%s", string(colorYellow), strings.Join(plan.MissingIds, ", "), string(colorReset))
+	}
+}
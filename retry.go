@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runRetry migrates the devices of a failed devices report again. The report
+// replaces the device list, so every other migration flag is supported.
+func runRetry(arguments []string) int {
+	args := &cliArgs{}
+	var failedDevicesFile string
+
+	fs := newFlagSet("retry")
+	fs.StringVar(&failedDevicesFile, "failedDevices", "", "Path of a failed_devices CSV file written by a previous run (Required)")
+	initMigrationFlags(fs, args)
+	_ = fs.Parse(arguments)
+
+	if failedDevicesFile == "" {
+		fatalConfig("-failedDevices is a required parameter")
+	}
+	if args.DevicesFile != "" {
+		fatalConfig("-devicesCsv cannot be combined with -failedDevices")
+	}
+
+	deviceIds, err := readFailedDeviceIds(failedDevicesFile)
+	if err != nil {
+		fatalConfig("Unable to read failed devices file: ", err)
+	}
+	if len(deviceIds) == 0 {
+		fmt.Println(string(colorGreen), "\n\u2713 No failed devices to retry!", string(colorReset))
+		return exitSuccess
+	}
+
+	fmt.Println(string(colorGreen), "\n\u2713 Retrying", len(deviceIds), "failed devices", string(colorReset))
+	args.DeviceIds = deviceIds
+	return runMigration(args)
+}
+
+// readFailedDeviceIds returns the IDs of the devices in a failed devices
+// report, in the order they first appear. A device can fail several steps, so
+// it may have several rows.
+func readFailedDeviceIds(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the file is empty")
+	}
+
+	idColumn := -1
+	for i, column := range records[0] {
+		if strings.TrimSpace(column) == "deviceId" {
+			idColumn = i
+		}
+	}
+	if idColumn == -1 {
+		return nil, errors.New("the file has no deviceId column")
+	}
+
+	seen := make(map[string]bool)
+	deviceIds := make([]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		if idColumn >= len(record) {
+			continue
+		}
+		id := strings.TrimSpace(record[idColumn])
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		deviceIds = append(deviceIds, id)
+	}
+	return deviceIds, nil
+}
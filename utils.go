@@ -3,73 +3,17 @@ package main
 import (
 	"bufio"
 	"encoding/csv"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
 	"os"
-	"os/user"
-	"path/filepath"
-	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
-	cbiotcore "github.com/clearblade/go-iot"
-	"github.com/k0kubun/go-ansi"
-	"github.com/schollz/progressbar/v3"
+	"clearblade-iot-enterprise-migration/migrator"
 	"golang.org/x/term"
 )
 
-func fileExists(filename string) bool {
-	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
-		fmt.Println("File path does not exists: ", filename, "Error: ", err)
-		return false
-	}
-
-	return true
-}
-
-func readCsvFile(filePath string) [][]string {
-	f, err := os.Open(filePath)
-	if err != nil {
-		log.Fatalln("Unable to read input file: ", filePath, err)
-	}
-	defer f.Close()
-
-	records, err := csv.NewReader(f).ReadAll()
-	if err != nil {
-		log.Fatalln("Unable to parse file as CSV for: ", filePath, err)
-	}
-
-	return records
-}
-
-func getCBProjectID(filePath string) string {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		log.Fatalln("Error when opening json file: ", err)
-	}
-
-	var payload CBConfig
-	err = json.Unmarshal(content, &payload)
-	if err != nil {
-		log.Fatalln("Error during Unmarshal(): ", err)
-	}
-
-	return payload.Project
-}
-
-func getCBRegistryPath() string {
-	val, _ := getAbsPath(Args.cbServiceAccount)
-	parent := fmt.Sprintf("projects/%s/locations/%s/registries/%s", getCBProjectID(val), Args.cbRegistryRegion, Args.cbRegistryName)
-	return parent
-}
-
-func getCBDevicePath(deviceId string) string {
-	return fmt.Sprintf("%s/devices/%s", getCBRegistryPath(), deviceId)
-}
-
 func readInput(msg string) (string, error) {
 	fmt.Print(msg)
 
@@ -106,122 +50,78 @@ func readPassword(msg string) (string, error) {
 	return input, nil
 }
 
-func getProgressBar(total int, description string) *progressbar.ProgressBar {
-	description = string(colorYellow) + description + string(colorReset)
-	bar := progressbar.NewOptions(total,
-		progressbar.OptionSetWriter(ansi.NewAnsiStdout()),
-		progressbar.OptionEnableColorCodes(true),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionSetDescription(description),
-		progressbar.OptionShowCount(),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "[green]=[reset]",
-			SaucerHead:    "[green]>[reset]",
-			SaucerPadding: " ",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}))
-
-	return bar
-}
-
-func getSpinner(description string) *progressbar.ProgressBar {
-	description = string(colorYellow) + description + string(colorReset)
-	bar := progressbar.NewOptions(-1,
-		progressbar.OptionSetWriter(ansi.NewAnsiStdout()),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionSetDescription(description),
-		progressbar.OptionShowCount(),
-	)
-	return bar
-}
-
-func getAbsPath(path string) (string, error) {
-	if len(path) == 0 {
-		return path, nil
+func generateFailedDevicesCSV(errorLogs []migrator.ErrorLog) error {
+	currDir, err := os.Getwd()
+	if err != nil {
+		return err
 	}
 
-	if path[0] != '~' {
-		return strings.TrimSuffix(path, "\r"), nil
-	}
+	failedDevicesFile := fmt.Sprint(currDir, "/failed_devices_", time.Now().Format("2006-01-02T15:04:05"), ".csv")
 
-	if len(path) > 1 && path[1] != '/' && path[1] != '\\' {
-		return "", errors.New("cannot expand user-specific home dir")
+	if runtime.GOOS == "windows" {
+		failedDevicesFile = fmt.Sprint(currDir, "\\failed_devices_", time.Now().Format("2006-01-02T15-04-05"), ".csv")
 	}
 
-	usr, _ := user.Current()
-	dir := usr.HomeDir
-
-	return filepath.Join(dir, path[1:]), nil
-}
-
-func transform(device *cbiotcore.Device, deviceType string, csvFile string) map[string]interface{} {
-	cbDevice := map[string]interface{}{
-		"name":                   device.Id,
-		"enabled":                !device.Blocked,
-		"type":                   deviceType,
-		"allow_key_auth":         false,
-		"allow_certificate_auth": true,
+	f, err := os.OpenFile(failedDevicesFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
 	}
 
-	if csvFile != "" {
-		absColumnsCsvFilePath, err := getAbsPath(csvFile)
-		if err != nil {
-			log.Fatalln("Cannot resolve column mapping CSV filepath: ", err.Error())
-		}
-
-		if !fileExists(absColumnsCsvFilePath) {
-			log.Fatalln("Unable to locate column mapping CSV filepath: ", absColumnsCsvFilePath)
-		}
+	defer f.Close()
 
-		records := readCsvFile(csvFile)
-		for _, line := range records {
-			cbDevice[line[1]] = reflect.Indirect(reflect.ValueOf(&device)).FieldByName(line[0])
+	// Error messages often hold quotes and commas of API error bodies, which
+	// the CSV writer escapes so the report can be read back by retry
+	w := csv.NewWriter(f)
+	records := [][]string{{"context", "error", "deviceId"}}
+	for i := 0; i < len(errorLogs); i++ {
+		errMsg := ""
+		if errorLogs[i].Error != nil {
+			errMsg = errorLogs[i].Error.Error()
 		}
+		records = append(records, []string{errorLogs[i].Context, errMsg, errorLogs[i].DeviceId})
 	}
 
-	return cbDevice
-}
-
-func getTimeString(timestamp time.Time) string {
-	if timestamp.Unix() == 0 {
-		return ""
-	}
-	return timestamp.Format(time.RFC3339)
+	return w.WriteAll(records)
 }
 
-func generateFailedDevicesCSV(errorLogs []ErrorLog) error {
+// generateActiveKeysCSV writes the active keys generated for the created
+// devices to a CSV file only readable by the current user, as the keys are
+// not shown anywhere else.
+func generateActiveKeysCSV(activeKeys map[string]string) error {
 	currDir, err := os.Getwd()
 	if err != nil {
 		return err
 	}
 
-	failedDevicesFile := fmt.Sprint(currDir, "/failed_devices_", time.Now().Format("2006-01-02T15:04:05"), ".csv")
+	activeKeysFile := fmt.Sprint(currDir, "/active_keys_", time.Now().Format("2006-01-02T15:04:05"), ".csv")
 
 	if runtime.GOOS == "windows" {
-		failedDevicesFile = fmt.Sprint(currDir, "\\failed_devices_", time.Now().Format("2006-01-02T15-04-05"), ".csv")
+		activeKeysFile = fmt.Sprint(currDir, "\\active_keys_", time.Now().Format("2006-01-02T15-04-05"), ".csv")
 	}
 
-	f, err := os.OpenFile(failedDevicesFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(activeKeysFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
 
 	defer f.Close()
 
-	fileContents := "context,error,deviceId\n"
-	for i := 0; i < len(errorLogs); i++ {
-		errMsg := ""
-		if errorLogs[i].Error != nil {
-			errMsg = errorLogs[i].Error.Error()
-		}
-		fileContents += fmt.Sprintf(`%s,"%s",%s`, errorLogs[i].Context, errMsg, errorLogs[i].DeviceId)
-		fileContents += "\n"
+	deviceIds := make([]string, 0, len(activeKeys))
+	for deviceId := range activeKeys {
+		deviceIds = append(deviceIds, deviceId)
+	}
+	sort.Strings(deviceIds)
+
+	w := csv.NewWriter(f)
+	records := [][]string{{"deviceId", "activeKey"}}
+	for _, deviceId := range deviceIds {
+		records = append(records, []string{deviceId, activeKeys[deviceId]})
 	}
 
-	if _, err := f.WriteString(fileContents); err != nil {
+	if err := w.WriteAll(records); err != nil {
 		return err
 	}
 
+	fmt.Println("Active keys of", len(deviceIds), "devices written to", activeKeysFile)
 	return nil
 }
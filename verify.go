@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"clearblade-iot-enterprise-migration/migrator"
+)
+
+func runVerify(arguments []string) int {
+	args := &cliArgs{}
+	fs := newFlagSet("verify")
+	initMigrationFlags(fs, args)
+	_ = fs.Parse(arguments)
+	defer args.closeLog()
+
+	m, err := connectMigrator(args)
+	if err != nil {
+		return printError(err)
+	}
+	defer m.Close()
+
+	report, err := m.Verify()
+	if err != nil {
+		return printError(err)
+	}
+
+	printVerifyReport(report)
+	if len(report.Mismatches) > 0 || len(report.MissingIds) > 0 {
+		return exitPartialFailure
+	}
+	return exitSuccess
+}
+
+func printVerifyReport(report *migrator.VerifyReport) {
+	fmt.Println(string(colorCyan), "\n\n================= Verification Report =================\n", string(colorReset))
+
+	mismatched := make(map[string]bool)
+	for _, mismatch := range report.Mismatches {
+		mismatched[mismatch.DeviceId] = true
+		fmt.Printf("  %s: %s is %q, expected %q\n", mismatch.DeviceId, mismatch.Field, mismatch.Actual, mismatch.Expected)
+	}
+
+	total := report.Verified + len(mismatched)
+	if len(mismatched) == 0 {
+		fmt.Println(string(colorGreen), "\n\u2713 Verified", report.Verified, "/", total, "devices!", string(colorReset))
+	} else {
+		fmt.Println(string(colorRed), "\n\u2715", len(mismatched), "devices differ from the source. Verified", report.Verified, "/", total, "devices!", string(colorReset))
+	}
+
+	if len(report.MissingIds) > 0 {
+		fmt.Printf("%sWarning: the following device IDs were not found - %s\n%s", string(colorYellow), strings.Join(report.MissingIds, ", "), string(colorReset))
+	}
+}
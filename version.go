@@ -1,3 +0,0 @@
-package main
-
-const cbIotEnterpriseMigrationVersion = "v1.6.0"